@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+)
+
+// DefaultWriteBufferSize is the size of the buffer between the response body
+// and the output file.
+const DefaultWriteBufferSize = 64 << 10
+
+// flushInterval bounds how long written data may sit in the buffer, so that
+// an interrupted download loses little of what it received.
+const flushInterval = time.Second
+
+// writeFile writes body to file through a buffer of
+// Options.WriteBufferSize bytes, so small reads don't turn into many tiny
+// writes. The buffer is flushed periodically and before returning, also when
+// the download fails, as everything received up to then is valid.
+func (d *Downloader) writeFile(ctx context.Context, body io.Reader, file io.Writer, download *Download) error {
+	if d.opts.WriteBufferSize <= 0 {
+		return d.writeBody(ctx, body, file, download)
+	}
+
+	w := &periodicWriter{Writer: bufio.NewWriterSize(file, d.opts.WriteBufferSize), lastFlush: time.Now()}
+	err := d.writeBody(ctx, body, w, download)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// periodicWriter flushes its buffer at least every flushInterval.
+type periodicWriter struct {
+	*bufio.Writer
+	lastFlush time.Time
+}
+
+func (w *periodicWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if now := time.Now(); now.Sub(w.lastFlush) >= flushInterval {
+		w.lastFlush = now
+		err = w.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"go.uber.org/zap"
+)
+
+func TestWriteFileFlushes(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    io.Reader
+		want    []byte
+		wantErr bool
+	}{
+		{"complete", bytes.NewReader(testContent), testContent, false},
+		{"interrupted", io.MultiReader(bytes.NewReader(testContent[:1000]), iotest.ErrReader(errors.New("connection reset"))), testContent[:1000], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var file bytes.Buffer
+			d := newTestDownloader(t, "", "", Options{WriteBufferSize: DefaultWriteBufferSize})
+
+			err := d.writeFile(context.Background(), tt.body, &file, NewDownload("f", "f", 0))
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v", err)
+			}
+			if !bytes.Equal(file.Bytes(), tt.want) {
+				t.Errorf("wrote %d bytes, want %d", file.Len(), len(tt.want))
+			}
+		})
+	}
+}
+
+// BenchmarkWriteFile writes a file in small reads, as a slow link delivers
+// them, with and without a write buffer in front of the file.
+func BenchmarkWriteFile(b *testing.B) {
+	content := bytes.Repeat([]byte("x"), 4<<20)
+	for _, bench := range []struct {
+		name       string
+		bufferSize int
+	}{
+		{"unbuffered", 0},
+		{"buffered", DefaultWriteBufferSize},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			d := NewDownloader(context.Background(), "", "", Options{
+				Progress:        ProgressNone,
+				WriteBufferSize: bench.bufferSize,
+				MinReadBuffer:   512,
+				MaxReadBuffer:   512,
+			}, zap.NewNop())
+			path := filepath.Join(b.TempDir(), "file")
+			b.SetBytes(int64(len(content)))
+			for range b.N {
+				file, err := os.Create(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := d.writeFile(context.Background(), bytes.NewReader(content), file, NewDownload("f", path, 0)); err != nil {
+					b.Fatal(err)
+				}
+				file.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func xzFixture(t *testing.T, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdFixture(t *testing.T, content []byte) []byte {
+	t.Helper()
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return w.EncodeAll(content, nil)
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture func(*testing.T, []byte) []byte
+	}{
+		{name: "file.xz", fixture: xzFixture},
+		{name: "file.zst", fixture: zstdFixture},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := tt.fixture(t, testContent)
+			server := newTestServer(t, compressed, `"v1"`)
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.name)
+
+			download(t, server.URL+"/"+tt.name, path, Options{Decompress: true})
+			checkFile(t, path, compressed)
+			checkFile(t, filepath.Join(dir, "file"), testContent)
+
+			// The compressed file is kept intact, so a rerun skips it.
+			download(t, server.URL+"/"+tt.name, path, Options{Decompress: true})
+			if n := len(server.gets()); n != 1 {
+				t.Errorf("made %d GET requests over two runs, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDecompressByContentType(t *testing.T) {
+	compressed := xzFixture(t, testContent)
+	server := newTestServer(t, compressed, `"v1"`)
+	server.contentType = "application/x-xz"
+	path := filepath.Join(t.TempDir(), "download")
+
+	download(t, server.URL+"/download", path, Options{Decompress: true})
+	checkFile(t, path, compressed)
+	checkFile(t, path+decompressedSuffix, testContent)
+
+	download(t, server.URL+"/download", path, Options{Decompress: true})
+	if n := len(server.gets()); n != 1 {
+		t.Errorf("made %d GET requests over two runs, want 1", n)
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	compressed := xzFixture(t, testContent)
+	compressed[len(compressed)/2] ^= 0xff
+	server := newTestServer(t, compressed, "")
+	dir := t.TempDir()
+
+	_, err := newTestDownloader(t, server.URL+"/file.xz", filepath.Join(dir, "file.xz"), Options{Decompress: true}).Download(context.Background())
+	if err == nil {
+		t.Fatal("corrupt stream decompressed without error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("corrupt stream left a decompressed file: %v", err)
+	}
+}
+
+func TestHashDecompressed(t *testing.T) {
+	server := newTestServer(t, zstdFixture(t, testContent), "")
+	sum := sha256.Sum256(testContent)
+
+	digest, _, err := newTestDownloader(t, server.URL+"/file.zst", "", Options{Decompress: true}).Hash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sum[:]); digest != want {
+		t.Errorf("digest = %s, want %s", digest, want)
+	}
+}
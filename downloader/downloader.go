@@ -2,22 +2,35 @@ package downloader
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// maxDrainSize is the most drainBody reads from a body it discards.
+const maxDrainSize = 64 << 10
+
 type Download struct {
 	filename       string
 	outputPath     string
 	downloadedSize int64
 	totalSize      int64
+
+	startedAt time.Time
+	startSize int64
 }
 
 func NewDownload(filename string, outputPath string, totalSize int64) *Download {
@@ -28,131 +41,748 @@ func NewDownload(filename string, outputPath string, totalSize int64) *Download
 	}
 }
 
+// begin marks the start of the transfer, from which the speed is measured.
+func (d *Download) begin() {
+	d.startedAt = time.Now()
+	d.startSize = d.downloadedSize
+}
+
+// transferred returns the number of bytes received since begin, or zero if
+// the transfer has not begun.
+func (d *Download) transferred() int64 {
+	if d.startedAt.IsZero() {
+		return 0
+	}
+	return d.downloadedSize - d.startSize
+}
+
+// speed returns the average transfer rate in bytes per second since begin.
+func (d *Download) speed() float64 {
+	elapsed := time.Since(d.startedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(d.transferred()) / elapsed
+}
+
+// Options configures optional behaviour of a Downloader.
+type Options struct {
+	// ProxyFor maps a request host to the proxy its requests are routed through.
+	// Hosts without an entry use the proxy from the environment, if any.
+	ProxyFor map[string]*url.URL
+	// TerminalRedirects lists redirect status codes that are not followed.
+	// Such responses fail the download instead.
+	TerminalRedirects []int
+	// RequestIDHeader names a header carrying a request ID for correlation
+	// with server-side logs. RequestID fixes its value; when empty, a random
+	// UUID is generated per download.
+	RequestIDHeader string
+	RequestID       string
+	// MaxErrorLength caps the length of error messages returned by Download.
+	// Zero means no limit.
+	MaxErrorLength int
+	// SNI overrides the TLS server name sent and verified during the
+	// handshake, e.g. when connecting to a server by IP address.
+	SNI string
+	// Retries is how many more times an interrupted transfer is attempted,
+	// waiting RetryDelay in between. Each attempt resumes from what is on
+	// disk by then.
+	Retries    int
+	RetryDelay time.Duration
+	// ResetRetries is how many more times a download is attempted after the
+	// server resets or closes the connection, as flaky CDNs often do, on top
+	// of Retries. Resets are retried also before the first byte arrives.
+	ResetRetries int
+	// ReportInterval, if positive, writes a summary of the run to stderr at
+	// this interval: files done, bytes, current speed, ETA and recent
+	// failures. It stops when the download ends.
+	ReportInterval time.Duration
+	// StartupTimeout fails a request if no response byte arrives within this
+	// duration of its start. Zero means no limit.
+	StartupTimeout time.Duration
+	// SSHJump routes all connections through an SSH tunnel to this jump
+	// host, given as [user@]host[:port]. It authenticates with SSHKey, a
+	// private key file, and the SSH agent, and verifies the host against
+	// SSHKnownHosts, which defaults to ~/.ssh/known_hosts.
+	SSHJump       string
+	SSHKey        string
+	SSHKnownHosts string
+	// MaxConnections caps the number of open connections across all hosts.
+	// Zero means no limit.
+	MaxConnections int
+	// ETagCache skips downloads whose ETag, as reported by a HEAD request,
+	// matches the one recorded after the last successful download. ETags
+	// are recorded in an index file next to the output path.
+	ETagCache bool
+	// Decompress unpacks .xz and .zst downloads next to them after they
+	// complete, keeping the compressed file. Hash digests the decompressed
+	// bytes instead.
+	Decompress bool
+	// DirURL decides what to save a download as when no output path is given
+	// and the URL names a directory: DirURLIndex (the default),
+	// DirURLContentType or DirURLError.
+	DirURL string
+	// NameCase decides the case of filenames derived from the URL:
+	// NameCasePreserve (the default), NameCaseLower or NameCaseUpper. A
+	// converted name that is taken by a download from another URL fails
+	// with NameCollisionError.
+	NameCase string
+	// Since skips the download if the server reports a Last-Modified time
+	// before it. Files without Last-Modified are always downloaded.
+	Since time.Time
+	// NormalizeNewlines rewrites the line endings of text downloads to
+	// NewlineLF or NewlineCRLF once they complete. The file at the output
+	// path holds the converted text, while the bytes as received are kept
+	// next to it (see rawPath). Empty keeps the newlines as they are.
+	NormalizeNewlines string
+	// AllowEmpty saves an empty file when the server responds with 204 No
+	// Content, instead of failing with NoContentError.
+	AllowEmpty bool
+	// ExpectTotalSize aborts the download before any data is transferred
+	// if the size reported by the server differs. Zero disables the check.
+	ExpectTotalSize int64
+	// HashAlgorithm is the digest computed by Hash: md5, sha1, sha256 or
+	// sha512. Empty means DefaultHashAlgorithm. ExpectedHash, if set, is the
+	// hex digest the content must match.
+	HashAlgorithm string
+	ExpectedHash  string
+	// Preallocate reserves the full size of the file before writing to it.
+	// A preallocated file that is interrupted is downloaded again rather than
+	// resumed, as its size no longer tells how far the download got.
+	Preallocate bool
+	// MinReadBuffer and MaxReadBuffer bound the buffer the response body is
+	// read into, which adapts to the measured throughput. Zero values use
+	// DefaultMinReadBuffer and DefaultMaxReadBuffer.
+	MinReadBuffer int
+	MaxReadBuffer int
+	// WriteBufferSize is the size of the buffer in front of the output
+	// file. Zero or less writes every read straight through.
+	WriteBufferSize int
+	// Storage is where the download is written, along with the files kept
+	// next to it. Nil means the local filesystem.
+	Storage Storage
+	// Progress selects how progress is shown: ProgressBar, ProgressKV,
+	// ProgressLog or ProgressNone. ProgressInterval sets how often key=value
+	// and log lines are emitted; zero picks a default for the mode.
+	Progress         string
+	ProgressInterval time.Duration
+}
+
 type Downloader struct {
 	url        string
 	outputPath string
+	opts       Options
+	requestID  string
+	storage    Storage
 	client     *http.Client
+	progress   progressRenderer
+	reporter   *statusReporter
 	logger     *zap.Logger
 }
 
-func NewDownloader(ctx context.Context, url string, outputPath string, logger *zap.Logger) *Downloader {
+func NewDownloader(ctx context.Context, url string, outputPath string, opts Options, logger *zap.Logger) *Downloader {
+	client := &http.Client{
+		Transport:     newTransport(opts),
+		CheckRedirect: checkRedirect(opts),
+	}
+	requestID := opts.RequestID
+	if opts.RequestIDHeader != "" && requestID == "" {
+		requestID = newUUID()
+	}
+	storage := opts.Storage
+	if storage == nil {
+		storage = OSStorage{}
+	}
+	progress := newProgressRenderer(opts, logger)
+	var reporter *statusReporter
+	if opts.ReportInterval > 0 {
+		reporter = newStatusReporter(os.Stderr, opts.ReportInterval)
+		progress = reportingProgress{progressRenderer: progress, reporter: reporter}
+	}
 	return &Downloader{
 		url:        url,
 		outputPath: outputPath,
-		client:     &http.Client{},
+		opts:       opts,
+		requestID:  requestID,
+		storage:    storage,
+		client:     client,
+		progress:   progress,
+		reporter:   reporter,
 		logger:     logger,
 	}
 }
 
-func (d *Downloader) Download(ctx context.Context) error {
-	return d.downloadFile(ctx)
+// DownloadResult describes how a download went. The timings cover every
+// request made for the download: connect and TLS durations add up across new
+// connections, while TTFB is that of the last request.
+type DownloadResult struct {
+	URL        string
+	OutputPath string
+	// BytesDownloaded counts the bytes transferred by this run, across all
+	// attempts, not including data kept from an earlier run. It is set also
+	// when the download fails partway.
+	BytesDownloaded int64
+	ConnectDuration time.Duration
+	TLSDuration     time.Duration
+	TTFB            time.Duration
+}
+
+// Download saves the file to the output path. The result is returned even
+// when the download fails.
+func (d *Downloader) Download(ctx context.Context) (*DownloadResult, error) {
+	result := &DownloadResult{URL: d.url, OutputPath: d.outputPath}
+	timings := &requestTimings{}
+	ctx = httptrace.WithClientTrace(ctx, timings.clientTrace())
+
+	if d.reporter != nil {
+		d.reporter.start()
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.reporter.run(stop)
+		}()
+		// No report may follow the end of the download.
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	var err error
+	retries, resets := 0, 0
+	for attempt := 1; ; attempt++ {
+		err = d.downloadFile(ctx, result)
+		if err == nil {
+			if d.reporter != nil {
+				d.reporter.complete()
+			}
+			break
+		}
+		if d.reporter != nil {
+			d.reporter.fail(err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		// Resets use up their own budget first, then that of other
+		// interrupted transfers.
+		if resets < d.opts.ResetRetries && isConnReset(err) {
+			resets++
+		} else if retries < d.opts.Retries && isInterrupted(err) {
+			retries++
+		} else {
+			break
+		}
+
+		d.logger.Info("Download interrupted, retrying", zap.String("url", d.url), zap.Int("attempt", attempt), zap.Error(err))
+		if !sleep(ctx, d.opts.RetryDelay) {
+			break
+		}
+	}
+	timings.apply(result)
+	return result, truncateError(err, d.opts.MaxErrorLength)
+}
+
+// isInterrupted reports whether a download failed partway through the
+// transfer, so that another attempt can resume where it stopped.
+func isInterrupted(err error) bool {
+	var transferErr *TransferError
+	return errors.As(err, &transferErr)
+}
+
+// isConnReset reports whether err is the server resetting or closing the
+// connection during the HTTP exchange, before or during the transfer. The
+// same causes elsewhere, such as in the SSH handshake of a jump host or in a
+// truncated compressed stream, don't count.
+func isConnReset(err error) bool {
+	var cause error
+	var transferErr *TransferError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &transferErr):
+		cause = transferErr.Err
+	case errors.As(err, &urlErr):
+		cause = urlErr.Err
+	default:
+		return false
+	}
+	if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(cause, &opErr) && opErr.Op != "dial" && errors.Is(opErr.Err, syscall.ECONNRESET)
+}
+
+// sleep waits for the given duration, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
 
-func (d *Downloader) downloadFile(ctx context.Context) error {
+// newRequest builds a request for the download URL, carrying the request ID
+// header if one is configured.
+func (d *Downloader) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.RequestIDHeader != "" {
+		req.Header.Set(d.opts.RequestIDHeader, d.requestID)
+	}
+	return req, nil
+}
+
+func (d *Downloader) downloadFile(ctx context.Context, result *DownloadResult) error {
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return err
+	}
+	handler := lookupScheme(u.Scheme)
+	if handler == nil && u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	if d.outputPath != "" {
+		if err := d.checkOutputPath(); err != nil {
+			return err
+		}
+	}
+	if handler != nil {
+		return d.downloadWith(ctx, handler, u, result)
+	}
+	if d.opts.RequestIDHeader != "" {
+		d.logger.Info("Sending requests", zap.String("url", d.url), zap.String("header", d.opts.RequestIDHeader), zap.String("requestID", d.requestID))
+	}
+
 	// Get the file information
-	resp, err := d.client.Get(d.url)
+	resp, err := d.probe(ctx)
 	if err != nil {
 		return err
 	}
 	d.logger.Debug("Response headers", zap.Any("headers", resp.Header))
+	if resp.StatusCode == http.StatusNoContent {
+		resp.Body.Close()
+		return d.saveNoContent(result)
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return err
+	}
+
+	if err := d.resolveOutputPath(resp.Header.Get("Content-Type"), result); err != nil {
+		drainBody(resp.Body)
+		return err
+	}
 
 	size := getFileSize(resp)
 	if size == 0 {
+		resp.Body.Close()
 		return errors.New("file size is 0")
 	}
+	if err := d.checkExpectedSize(size); err != nil {
+		drainBody(resp.Body)
+		return err
+	}
 
-	download := NewDownload(d.url, d.outputPath, size)
-	// Check if the file already exists
-	info, err := os.Stat(d.outputPath)
+	etag := resp.Header.Get("ETag")
+	if d.opts.ETagCache {
+		cached, err := d.etagUnchanged(etag)
+		if err != nil {
+			drainBody(resp.Body)
+			return err
+		}
+		if cached {
+			d.logger.Info("ETag unchanged since last download, skipping", zap.String("url", d.url), zap.String("outputPath", d.outputPath))
+			drainBody(resp.Body)
+			return nil
+		}
+	}
+
+	if !d.opts.Since.IsZero() {
+		if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && modified.Before(d.opts.Since) {
+			d.logger.Info("File not modified since cutoff, skipping", zap.String("url", d.url), zap.Time("lastModified", modified), zap.Time("since", d.opts.Since))
+			drainBody(resp.Body)
+			return nil
+		}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	download := NewDownload(d.url, d.savePath(contentType), size)
+	// Report progress also when the download fails partway.
+	defer func() { result.BytesDownloaded += download.transferred() }()
+	if err := d.saveResponse(ctx, resp, download); err != nil {
+		return err
+	}
+	return d.finish(download, contentType, etag)
+}
+
+// checkExpectedSize fails unless the announced size of the file is the one
+// given by Options.ExpectTotalSize. A negative size stands for an unknown one.
+func (d *Downloader) checkExpectedSize(size int64) error {
+	if d.opts.ExpectTotalSize <= 0 {
+		return nil
+	}
+	if size < 0 {
+		return fmt.Errorf("%s: size unknown, expected %d bytes", d.url, d.opts.ExpectTotalSize)
+	}
+	if size != d.opts.ExpectTotalSize {
+		return &SizeMismatchError{URL: d.url, Expected: d.opts.ExpectTotalSize, Actual: size}
+	}
+	return nil
+}
+
+// savePath returns where a download with the given Content-Type is written
+// to: the output path, or next to it when the output path receives a
+// converted copy.
+func (d *Downloader) savePath(contentType string) string {
+	if d.normalizes(contentType) {
+		return rawPath(d.outputPath)
+	}
+	return d.outputPath
+}
+
+// finish runs the steps that follow a completed download: unpacking,
+// converting newlines and recording the download.
+func (d *Downloader) finish(download *Download, contentType string, etag string) error {
+	if d.opts.Decompress {
+		if err := d.decompress(contentType); err != nil {
+			return err
+		}
+	}
+	if download.outputPath != d.outputPath {
+		if err := d.normalizeNewlines(download.outputPath); err != nil {
+			return err
+		}
+	}
+	return d.recordDownload(etag)
+}
+
+// saveNoContent handles a 204 No Content response, which has no file to save.
+// It fails with NoContentError unless empty files are allowed, in which case
+// an empty file is written.
+func (d *Downloader) saveNoContent(result *DownloadResult) error {
+	if !d.opts.AllowEmpty {
+		return &NoContentError{URL: d.url}
+	}
+
+	if err := d.resolveOutputPath("", result); err != nil {
+		return err
+	}
+
+	d.logger.Info("Server returned no content, saving an empty file", zap.String("url", d.url), zap.String("outputPath", d.outputPath))
+	file, err := d.storage.Create(d.outputPath)
 	if err != nil {
-		return d.startDownload(ctx, resp, download)
+		return err
 	}
+	return file.Close()
+}
 
-	if info.Size() == size {
-		d.logger.Debug("File already exists", zap.String("url", d.url), zap.String("outputPath", d.outputPath))
+// resolveOutputPath derives the output path from the URL if none was given,
+// and checks that the derived path can be written.
+func (d *Downloader) resolveOutputPath(contentType string, result *DownloadResult) error {
+	if d.outputPath != "" {
 		return nil
 	}
 
-	if info.Size() > size || info.Size() == 0 {
-		d.logger.Debug("File is incomplete or corrupted, downloading again", zap.String("url", d.url), zap.String("outputPath", d.outputPath))
+	name, err := d.deriveFilename(contentType)
+	if err != nil {
+		return err
+	}
+	d.outputPath = name
+	result.OutputPath = name
+	if err := d.checkOutputPath(); err != nil {
+		return err
+	}
+	if d.normalizesNameCase() {
+		return d.checkNameCollision()
+	}
+	return nil
+}
+
+// checkOutputPath fails early when the output path can't be written as a
+// file, rather than after the server has been contacted.
+func (d *Downloader) checkOutputPath() error {
+	if info, err := d.storage.Stat(d.outputPath); err == nil && info.IsDir() {
+		return fmt.Errorf("output path %s is a directory", d.outputPath)
+	}
+
+	dir := filepath.Dir(d.outputPath)
+	if info, err := d.storage.Stat(dir); err == nil && !info.IsDir() {
+		return fmt.Errorf("output directory %s is not a directory", dir)
+	}
+	return nil
+}
+
+// probe requests the file information with HEAD, so that no body is fetched
+// when the download turns out to be complete already. Servers that refuse
+// HEAD, or answer it without a Content-Length, are asked with GET instead; the
+// body of that response is reused or drained by the caller.
+func (d *Downloader) probe(ctx context.Context) (*http.Response, error) {
+	req, err := d.newRequest(ctx, http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK && getFileSize(resp) > 0 {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		d.logger.Debug("HEAD response has no size, falling back to GET", zap.String("url", d.url))
+	} else {
+		d.logger.Debug("HEAD request failed, falling back to GET", zap.String("url", d.url), zap.Int("status", resp.StatusCode))
+	}
+	req, err = d.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Do(req)
+}
+
+// get returns a GET response for the file starting at offset. The probe
+// response is reused when it already is one, and drained otherwise.
+func (d *Downloader) get(ctx context.Context, probe *http.Response, offset int64) (*http.Response, error) {
+	if probe.Request.Method == http.MethodGet && offset == 0 {
+		return probe, nil
+	}
+	drainBody(probe.Body)
+
+	req, err := d.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// Have the server send the whole file instead if it changed since
+		// the probe. Weak ETags can't be used for this.
+		if etag := probe.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			req.Header.Set("If-Range", etag)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// Left to the caller, which checks whether the file is complete.
+		return resp, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// saveResponse writes the file to the path of download, resuming or skipping
+// based on what is already on disk. A partial file is only resumed if the size and
+// ETag recorded when its download started still match; any change on the
+// server side forces a clean restart.
+func (d *Downloader) saveResponse(ctx context.Context, probe *http.Response, download *Download) error {
+	size := download.totalSize
+	statePath := resumeStatePath(download.outputPath)
+	state, err := d.loadResumeState(statePath)
+	if err != nil {
+		drainBody(probe.Body)
+		return err
+	}
+	current := resumeState{Size: size, ETag: probe.Header.Get("ETag")}
+
+	// Check if the file already exists
+	// A recorded state means the download has not completed, even if the
+	// file has its full size, as happens when it was preallocated.
+	info, err := d.storage.Stat(download.outputPath)
+	if err == nil && info.Size() == size && state == nil {
+		d.logger.Debug("File already exists", zap.String("url", d.url), zap.String("outputPath", download.outputPath))
+		drainBody(probe.Body)
+		return d.removeResumeState(statePath)
+	}
+
+	if err == nil && info.Size() > 0 && info.Size() < size && state != nil && *state == current {
+		download.downloadedSize = info.Size()
+	} else {
+		if err == nil {
+			d.logger.Debug("File is incomplete, changed or corrupted, downloading again", zap.String("url", d.url), zap.String("outputPath", download.outputPath))
+		}
+		if err := d.saveResumeState(statePath, current); err != nil {
+			drainBody(probe.Body)
+			return err
+		}
+	}
+
+	if err := d.transfer(ctx, probe, download); err != nil {
+		return err
+	}
+	if err := d.verifySize(download); err != nil {
+		return err
+	}
+	return d.removeResumeState(statePath)
+}
+
+// transfer fetches the file, or the rest of it if the download has made
+// progress already.
+func (d *Downloader) transfer(ctx context.Context, probe *http.Response, download *Download) error {
+	if download.downloadedSize == 0 {
+		resp, err := d.get(ctx, probe, 0)
+		if err != nil {
+			return err
+		}
+		return d.startDownload(ctx, resp, download)
+	}
+
+	resp, err := d.get(ctx, probe, download.downloadedSize)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// A range starting at the end of the file is not satisfiable, so the
+		// file may be complete already despite the size reported earlier.
+		total, ok := unsatisfiedRangeSize(resp)
+		drainBody(resp.Body)
+		if !ok || total != download.downloadedSize {
+			return &StatusError{URL: d.url, StatusCode: resp.StatusCode}
+		}
+		d.logger.Debug("File already complete", zap.String("url", d.url), zap.String("outputPath", download.outputPath))
+		download.totalSize = total
+		return nil
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		d.logger.Debug("Server ignored the range request, downloading again", zap.String("url", d.url), zap.String("outputPath", download.outputPath))
+		download.downloadedSize = 0
 		return d.startDownload(ctx, resp, download)
 	}
 
-	download.downloadedSize = info.Size()
-	d.logger.Debug("Continuing download", zap.String("url", d.url), zap.String("path", d.outputPath))
+	d.logger.Debug("Continuing download", zap.String("url", d.url), zap.String("path", download.outputPath))
 	return d.continueDownload(ctx, resp, download)
 }
 
-func (d *Downloader) startDownload(ctx context.Context, resp *http.Response, download *Download) error {
-	file, err := os.Create(download.outputPath)
+// verifySize checks that the saved file holds exactly what was downloaded and
+// what the server announced. A mismatch points at a filesystem or logic bug
+// rather than a network problem.
+func (d *Downloader) verifySize(download *Download) error {
+	info, err := d.storage.Stat(download.outputPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	defer resp.Body.Close()
+	if info.Size() != download.downloadedSize {
+		return &SizeMismatchError{URL: d.url, Expected: download.downloadedSize, Actual: info.Size()}
+	}
+	if download.totalSize > 0 && info.Size() != download.totalSize {
+		return &SizeMismatchError{URL: d.url, Expected: download.totalSize, Actual: info.Size()}
+	}
+	return nil
+}
 
+func (d *Downloader) startDownload(ctx context.Context, resp *http.Response, download *Download) error {
+	defer resp.Body.Close()
 	d.logger.Debug("Downloading file", zap.String("filename", download.filename), zap.String("size", prettySize(download.totalSize)))
-	buffer := make([]byte, 1024)
-	for {
-		select {
-		case <-ctx.Done():
-			d.logger.Info("Download cancelled by user")
-			return errors.New("download cancelled")
-		default:
-			n, err := resp.Body.Read(buffer)
-			if err != nil {
-				if err == io.EOF {
-					return nil
-				}
-				return err
-			}
+	return d.writeNewFile(ctx, resp.Body, download)
+}
 
-			_, err = file.Write(buffer[:n])
-			if err != nil {
-				return err
-			}
+// writeNewFile writes body to a new file at the path of download, reserving
+// its full size first if Options.Preallocate is set.
+func (d *Downloader) writeNewFile(ctx context.Context, body io.Reader, download *Download) (err error) {
+	file, err := d.storage.Create(download.outputPath)
+	if err != nil {
+		return err
+	}
+	// Storage backends may only commit the file when it is closed.
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
-			download.downloadedSize += int64(n)
-			updateProgress(download)
+	if d.opts.Preallocate && download.totalSize > 0 {
+		err := preallocate(file, download.totalSize)
+		if errors.Is(err, errors.ErrUnsupported) {
+			d.logger.Debug("Storage does not support preallocation", zap.String("path", download.outputPath))
+		} else if err != nil {
+			return fmt.Errorf("preallocating %s for %s: %w", prettySize(download.totalSize), download.outputPath, err)
 		}
 	}
+
+	return d.writeFile(ctx, body, file, download)
 }
 
-func (d *Downloader) continueDownload(ctx context.Context, resp *http.Response, download *Download) error {
-	file, err := os.OpenFile(download.outputPath, os.O_APPEND|os.O_WRONLY, 0644)
+func (d *Downloader) continueDownload(ctx context.Context, resp *http.Response, download *Download) (err error) {
+	defer resp.Body.Close()
+	file, err := d.storage.OpenAppend(download.outputPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	defer resp.Body.Close()
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
 	d.logger.Debug("Downloading file", zap.String("filename", download.filename), zap.String("remaining", prettySize(download.totalSize-download.downloadedSize)), zap.String("size", prettySize(download.totalSize)))
-	buffer := make([]byte, 1024)
+	return d.writeFile(ctx, resp.Body, file, download)
+}
+
+// writeBody copies body to file, updating the progress as it goes.
+func (d *Downloader) writeBody(ctx context.Context, body io.Reader, file io.Writer, download *Download) error {
+	buffer := newAdaptiveBuffer(d.opts.MinReadBuffer, d.opts.MaxReadBuffer)
+	download.begin()
 	for {
 		select {
 		case <-ctx.Done():
 			d.logger.Info("Download cancelled by user")
 			return errors.New("download cancelled")
 		default:
-			n, err := resp.Body.Read(buffer)
-			if err != nil {
-				if err == io.EOF {
-					return nil
+			n, err := body.Read(buffer.buf)
+			if n > 0 {
+				if _, err := file.Write(buffer.buf[:n]); err != nil {
+					return err
 				}
-				return err
-			}
+				buffer.observe(n)
 
-			_, err = file.Write(buffer[:n])
+				download.downloadedSize += int64(n)
+				d.progress.update(download)
+			}
+			if err == io.EOF {
+				d.progress.finish(download)
+				return nil
+			}
 			if err != nil {
-				return err
+				return &TransferError{URL: download.filename, Err: err}
 			}
-
-			download.downloadedSize += int64(n)
-			updateProgress(download)
 		}
 	}
 }
 
+// drainBody reads what is left of a small response body before closing it,
+// so the connection can be reused. Large bodies are abandoned instead.
+func drainBody(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrainSize)
+	body.Close()
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+
+	url := resp.Request.URL.String()
+	if isRedirect(resp.StatusCode) && resp.Header.Get("Location") == "" {
+		return &MissingLocationError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return &StatusError{URL: url, StatusCode: resp.StatusCode, Body: readBodySnippet(resp.Body)}
+}
+
 func getFileSize(resp *http.Response) int64 {
 	sizeFromHeader := resp.Header.Get("Content-Length")
 	if sizeFromHeader == "" {
@@ -167,19 +797,27 @@ func getFileSize(resp *http.Response) int64 {
 	return size
 }
 
-func updateProgress(download *Download) {
-	if download.totalSize == 0 {
-		fmt.Printf("\r%s: %s / unknown size", download.filename, prettySize(download.downloadedSize))
-		return
+// unsatisfiedRangeSize returns the file size from the Content-Range header of
+// a 416 response, which has the form "bytes */size".
+func unsatisfiedRangeSize(resp *http.Response) (int64, bool) {
+	size, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes */")
+	if !ok {
+		return 0, false
 	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
 
-	progress := float64(download.downloadedSize) / float64(download.totalSize) * 100
-	barWidth := 30
-	filledWidth := int(progress / 100 * float64(barWidth))
-	emptyWidth := barWidth - filledWidth
-
-	fmt.Printf("\r%s: [%s%s] %.2f%%", download.filename, strings.Repeat("█", filledWidth), strings.Repeat(" ", emptyWidth), progress)
-	os.Stdout.Sync()
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
 
 func prettySize(size int64) string {
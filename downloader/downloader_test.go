@@ -0,0 +1,475 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testContent is the file served by newTestServer unless a test sets another.
+var testContent = []byte(strings.Repeat("hello world\n", 10000))
+
+// testServer serves a single file at every path, with Range and If-Range
+// support, and records the requests it gets.
+type testServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	content     []byte
+	etag        string
+	contentType string
+	requests    []*http.Request
+	// intercept, if set, sees each request first, numbered from 0. It
+	// returns the writer to serve the file through, or nil if it handled
+	// the request itself.
+	intercept func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter
+}
+
+func newTestServer(t *testing.T, content []byte, etag string) *testServer {
+	t.Helper()
+	s := &testServer{content: content, etag: etag}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := len(s.requests)
+	s.requests = append(s.requests, r.Clone(context.Background()))
+	content, etag, contentType, intercept := s.content, s.etag, s.contentType, s.intercept
+	s.mu.Unlock()
+
+	if intercept != nil {
+		if w = intercept(n, w, r); w == nil {
+			return
+		}
+	}
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// setContent replaces the served file.
+func (s *testServer) setContent(content []byte, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content = content
+	s.etag = etag
+}
+
+// gets returns the GET requests received so far.
+func (s *testServer) gets() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var gets []*http.Request
+	for _, req := range s.requests {
+		if req.Method == http.MethodGet {
+			gets = append(gets, req)
+		}
+	}
+	return gets
+}
+
+func newTestDownloader(t *testing.T, url string, outputPath string, opts Options) *Downloader {
+	t.Helper()
+	if opts.Progress == "" {
+		opts.Progress = ProgressNone
+	}
+	return NewDownloader(context.Background(), url, outputPath, opts, zap.NewNop())
+}
+
+// download runs a download with the given options and fails the test on
+// error.
+func download(t *testing.T, url string, outputPath string, opts Options) *DownloadResult {
+	t.Helper()
+	result, err := newTestDownloader(t, url, outputPath, opts).Download(context.Background())
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	return result
+}
+
+// checkFile fails the test unless the file at path holds want.
+func checkFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: got %d bytes, want %d bytes of the served file", filepath.Base(path), len(got), len(want))
+	}
+}
+
+// rangesOf returns the Range headers of requests, for error messages.
+func rangesOf(requests []*http.Request) []string {
+	ranges := make([]string, len(requests))
+	for i, req := range requests {
+		ranges[i] = req.Method + " " + req.Header.Get("Range")
+	}
+	return ranges
+}
+
+// resetConn resets the connection of a request, as a crashing server or a
+// flaky CDN does.
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+}
+
+// cutOffWriter passes the headers and the first n bytes of the body through,
+// then closes the connection. Unlike a reset, this delivers all n bytes.
+type cutOffWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *cutOffWriter) Write(p []byte) (int, error) {
+	if len(p) < w.n {
+		w.n -= len(p)
+		return w.ResponseWriter.Write(p)
+	}
+	w.ResponseWriter.Write(p[:w.n])
+	w.ResponseWriter.(http.Flusher).Flush()
+	if conn, _, err := w.ResponseWriter.(http.Hijacker).Hijack(); err == nil {
+		conn.Close()
+	}
+	return 0, errors.New("connection cut off")
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	dir := t.TempDir()
+	core, logs := observer.New(zap.InfoLevel)
+	opts := Options{RequestIDHeader: "X-Request-ID", Progress: ProgressNone}
+
+	for _, name := range []string{"a", "b"} {
+		d := NewDownloader(context.Background(), srv.URL+"/file", filepath.Join(dir, name), opts, zap.New(core))
+		if _, err := d.Download(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, req := range srv.requests {
+		id := req.Header.Get("X-Request-ID")
+		if id == "" {
+			t.Fatalf("%s request without a request ID", req.Method)
+		}
+		seen[id] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("got request IDs %v, want one per download", seen)
+	}
+	for id := range seen {
+		if logs.FilterField(zap.String("requestID", id)).Len() == 0 {
+			t.Errorf("request ID %s was not logged", id)
+		}
+	}
+}
+
+func TestRequestIDFixed(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	download(t, srv.URL+"/file", filepath.Join(t.TempDir(), "file"), Options{
+		RequestIDHeader: "X-Correlation-ID",
+		RequestID:       "build-42",
+	})
+
+	if len(srv.requests) == 0 {
+		t.Fatal("no requests reached the server")
+	}
+	for _, req := range srv.requests {
+		if got := req.Header.Get("X-Correlation-ID"); got != "build-42" {
+			t.Errorf("%s request carried %q, want build-42", req.Method, got)
+		}
+	}
+}
+
+func TestSkipReusesConnection(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 10000)
+	for _, headAllowed := range []bool{true, false} {
+		name := "HEAD"
+		if !headAllowed {
+			name = "GET fallback"
+		}
+		t.Run(name, func(t *testing.T) {
+			srv := newTestServer(t, content, "")
+			var mu sync.Mutex
+			conns := map[string]bool{}
+			srv.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+				mu.Lock()
+				conns[r.RemoteAddr] = true
+				mu.Unlock()
+				if r.Method == http.MethodHead && !headAllowed {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return nil
+				}
+				return w
+			}
+			path := filepath.Join(t.TempDir(), "file")
+			d := newTestDownloader(t, srv.URL+"/file", path, Options{})
+
+			for range 3 {
+				if _, err := d.Download(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkFile(t, path, content)
+			if len(conns) != 1 {
+				t.Errorf("used %d connections, want the first one reused", len(conns))
+			}
+		})
+	}
+}
+
+func TestExpectTotalSize(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	dir := t.TempDir()
+
+	download(t, srv.URL+"/file", filepath.Join(dir, "match"), Options{ExpectTotalSize: int64(len(testContent))})
+	checkFile(t, filepath.Join(dir, "match"), testContent)
+
+	path := filepath.Join(dir, "mismatch")
+	_, err := newTestDownloader(t, srv.URL+"/file", path, Options{ExpectTotalSize: 1000}).Download(context.Background())
+	var mismatch *SizeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err = %v, want a SizeMismatchError", err)
+	}
+	if mismatch.Expected != 1000 || mismatch.Actual != int64(len(testContent)) {
+		t.Errorf("mismatch = %+v", mismatch)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("output written despite the mismatch: %v", err)
+	}
+	if n := len(srv.gets()); n != 1 {
+		t.Errorf("got %d GETs, want only the one for the matching download", n)
+	}
+}
+
+func TestOutputDirectoryIsFile(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "regular")
+	if err := os.WriteFile(notDir, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		outputPath string
+		want       string
+	}{
+		{"parent is a file", filepath.Join(notDir, "file"), "output directory " + notDir + " is not a directory"},
+		{"output is a directory", dir, "output path " + dir + " is a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestDownloader(t, srv.URL+"/file", tt.outputPath, Options{}).Download(context.Background())
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if len(srv.requests) != 0 {
+		t.Errorf("got %d requests, want the error before any", len(srv.requests))
+	}
+}
+
+func TestDownloadTimings(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(delay)
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					return
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(testContent))
+			}))
+			t.Cleanup(srv.Close)
+			d := newTestDownloader(t, srv.URL+"/file", filepath.Join(t.TempDir(), "file"), Options{})
+			d.client.Transport.(*http.Transport).TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+			result, err := d.Download(context.Background())
+
+			if (err != nil) != (status != http.StatusOK) {
+				t.Fatalf("Download: %v", err)
+			}
+			if result.ConnectDuration <= 0 {
+				t.Errorf("ConnectDuration = %v, want it measured", result.ConnectDuration)
+			}
+			if result.TLSDuration <= 0 {
+				t.Errorf("TLSDuration = %v, want it measured", result.TLSDuration)
+			}
+			if result.TTFB < delay {
+				t.Errorf("TTFB = %v, want at least the server delay of %v", result.TTFB, delay)
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		lastModified time.Time
+		wantSaved    bool
+	}{
+		{"older", cutoff.Add(-time.Hour), false},
+		{"newer", cutoff.Add(time.Hour), true},
+		{"no Last-Modified", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, testContent, "")
+			srv.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+				if !tt.lastModified.IsZero() {
+					w.Header().Set("Last-Modified", tt.lastModified.Format(http.TimeFormat))
+				}
+				return w
+			}
+			path := filepath.Join(t.TempDir(), "file")
+
+			download(t, srv.URL+"/file", path, Options{Since: cutoff})
+
+			if tt.wantSaved {
+				checkFile(t, path, testContent)
+				return
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("file older than the cutoff was saved: %v", err)
+			}
+			if n := len(srv.gets()); n != 0 {
+				t.Errorf("got %d GETs, want only the HEAD", n)
+			}
+		})
+	}
+}
+
+func TestBytesDownloadedOnFailure(t *testing.T) {
+	srv := newTestServer(t, testContent, `"v1"`)
+	srv.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodGet {
+			return &cutOffWriter{ResponseWriter: w, n: 25000}
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	result, err := newTestDownloader(t, srv.URL+"/file", path, Options{}).Download(context.Background())
+
+	var transferErr *TransferError
+	if !errors.As(err, &transferErr) {
+		t.Fatalf("err = %v, want a TransferError", err)
+	}
+	if result.BytesDownloaded != 25000 {
+		t.Errorf("BytesDownloaded = %d, want 25000", result.BytesDownloaded)
+	}
+	checkFile(t, path, testContent[:25000])
+}
+
+func TestVerifySize(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	storage := lossyStorage{newMemStorage()}
+
+	_, err := newTestDownloader(t, srv.URL+"/file", "file", Options{Storage: storage}).Download(context.Background())
+
+	var mismatch *SizeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err = %v, want a SizeMismatchError", err)
+	}
+	if mismatch.Expected != int64(len(testContent)) || mismatch.Actual != int64(len(storage.content("file"))) {
+		t.Errorf("mismatch = %+v, stored %d bytes", mismatch, len(storage.content("file")))
+	}
+}
+
+// lossyStorage is a storage that silently drops the last byte of every
+// write, as a buggy filesystem might.
+type lossyStorage struct {
+	*memStorage
+}
+
+func (s lossyStorage) Create(name string) (io.WriteCloser, error) {
+	w, err := s.memStorage.Create(name)
+	return lossyWriter{w}, err
+}
+
+type lossyWriter struct {
+	io.WriteCloser
+}
+
+func (w lossyWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := w.WriteCloser.Write(p[:len(p)-1]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func TestNoContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "refused")
+	_, err := newTestDownloader(t, srv.URL+"/file", path, Options{}).Download(context.Background())
+	var noContent *NoContentError
+	if !errors.As(err, &noContent) {
+		t.Fatalf("err = %v, want a NoContentError", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file saved without AllowEmpty: %v", err)
+	}
+
+	path = filepath.Join(dir, "empty")
+	download(t, srv.URL+"/file", path, Options{AllowEmpty: true})
+	checkFile(t, path, []byte{})
+}
+
+func TestHeadWithoutSize(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	srv.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	download(t, srv.URL+"/file", path, Options{})
+
+	checkFile(t, path, testContent)
+	if got := rangesOf(srv.requests); !slices.Equal(got, []string{"HEAD ", "GET "}) {
+		t.Errorf("requests = %q, want the GET to carry the size", got)
+	}
+}
@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBodySnippet is the number of bytes of an error response body kept in a
+// StatusError.
+const maxBodySnippet = 512
+
+// StatusError is returned when the server responds with a status code that
+// does not carry the file.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// Body holds the start of the response body, which often explains the
+	// failure. It is at most maxBodySnippet bytes long.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s: unexpected status %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// readBodySnippet reads the start of an error response body.
+func readBodySnippet(body io.Reader) string {
+	snippet, _ := io.ReadAll(io.LimitReader(body, maxBodySnippet))
+	return strings.TrimSpace(strings.ToValidUTF8(string(snippet), ""))
+}
+
+// MissingLocationError is returned when the server responds with a redirect
+// status but no Location header to follow.
+type MissingLocationError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *MissingLocationError) Error() string {
+	return fmt.Sprintf("%s: redirect status %d %s without a Location header", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// truncatedError shortens the message of an error while keeping it available
+// to errors.Is and errors.As.
+type truncatedError struct {
+	msg string
+	err error
+}
+
+func (e *truncatedError) Error() string {
+	return e.msg
+}
+
+func (e *truncatedError) Unwrap() error {
+	return e.err
+}
+
+// truncateError limits the message of err to maxLength bytes, cutting at a
+// rune boundary so that server text in the message stays valid UTF-8. A
+// maxLength of zero or less leaves err unchanged.
+func truncateError(err error, maxLength int) error {
+	if err == nil || maxLength <= 0 {
+		return err
+	}
+
+	msg := err.Error()
+	if len(msg) <= maxLength {
+		return err
+	}
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return &truncatedError{msg: msg[:cut] + "... (truncated)", err: err}
+}
+
+// SizeMismatchError is returned when the size of a download differs from the
+// expected one.
+type SizeMismatchError struct {
+	URL      string
+	Expected int64
+	Actual   int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("%s: expected %d bytes, got %d", e.URL, e.Expected, e.Actual)
+}
+
+// ChecksumMismatchError is returned when the digest of a download differs
+// from the expected one.
+type ChecksumMismatchError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s: checksum mismatch: expected %s, got %s", e.URL, e.Expected, e.Actual)
+}
+
+// StartupTimeoutError is returned when the server sends no response within
+// the startup timeout of a request.
+type StartupTimeoutError struct {
+	URL     string
+	Timeout time.Duration
+}
+
+func (e *StartupTimeoutError) Error() string {
+	return fmt.Sprintf("%s: no response within %s", e.URL, e.Timeout)
+}
+
+// RedirectLoopError is returned when a redirect leads back to a URL visited
+// earlier in the redirect chain.
+type RedirectLoopError struct {
+	// Cycle lists the URLs of the loop, starting and ending with the
+	// repeated one.
+	Cycle []string
+}
+
+func newRedirectLoopError(cycle []*http.Request, next *http.Request) *RedirectLoopError {
+	urls := make([]string, 0, len(cycle)+1)
+	for _, req := range cycle {
+		urls = append(urls, req.URL.String())
+	}
+	return &RedirectLoopError{Cycle: append(urls, next.URL.String())}
+}
+
+func (e *RedirectLoopError) Error() string {
+	return "redirect loop detected: " + strings.Join(e.Cycle, " -> ")
+}
+
+// TransferError is returned when reading the file fails partway through a
+// download.
+type TransferError struct {
+	URL string
+	Err error
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("%s: transfer interrupted: %v", e.URL, e.Err)
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// NoContentError is returned when the server responds with 204 No Content,
+// so there is no file to save.
+type NoContentError struct {
+	URL string
+}
+
+func (e *NoContentError) Error() string {
+	return fmt.Sprintf("%s: server returned 204 No Content, nothing to save", e.URL)
+}
+
+// NameCollisionError is returned when the filename derived for a download is
+// that of a file saved from another URL, because the names were normalized to
+// the same case.
+type NameCollisionError struct {
+	URL      string
+	Path     string
+	OtherURL string
+}
+
+func (e *NameCollisionError) Error() string {
+	return fmt.Sprintf("%s: %s already holds %s; pass an output path with -o", e.URL, e.Path, e.OtherURL)
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateError(t *testing.T) {
+	err := &StatusError{URL: "http://example.com/f", StatusCode: 403, Body: strings.Repeat("x", 1000)}
+
+	truncated := truncateError(err, 40)
+	if got, want := truncated.Error(), err.Error()[:40]+"... (truncated)"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	var statusErr *StatusError
+	if !errors.As(truncated, &statusErr) || statusErr != err {
+		t.Errorf("errors.As lost the StatusError")
+	}
+
+	if got := truncateError(err, 0); got != err {
+		t.Errorf("maxLength 0 changed the error")
+	}
+	if got := truncateError(err, 10000); got != err {
+		t.Errorf("short error was changed")
+	}
+}
+
+func TestTruncateErrorRuneBoundary(t *testing.T) {
+	err := errors.New("denied: ééééé")
+	for maxLength := 1; maxLength < len(err.Error()); maxLength++ {
+		msg := truncateError(err, maxLength).Error()
+		prefix := strings.TrimSuffix(msg, "... (truncated)")
+		if !utf8.ValidString(msg) {
+			t.Errorf("maxLength %d: invalid UTF-8 in %q", maxLength, msg)
+		}
+		if len(prefix) > maxLength {
+			t.Errorf("maxLength %d: kept %d bytes", maxLength, len(prefix))
+		}
+	}
+}
+
+func TestStatusErrorBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"short", "Access denied: token expired\n", "Access denied: token expired"},
+		{"long", strings.Repeat("y", 2000), strings.Repeat("y", maxBodySnippet)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, tt.body, http.StatusForbidden)
+			}))
+			t.Cleanup(srv.Close)
+
+			_, err := newTestDownloader(t, srv.URL+"/file", filepath.Join(t.TempDir(), "file"), Options{}).Download(context.Background())
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("err = %v, want a StatusError", err)
+			}
+			if statusErr.StatusCode != http.StatusForbidden {
+				t.Errorf("StatusCode = %d, want %d", statusErr.StatusCode, http.StatusForbidden)
+			}
+			if statusErr.Body != tt.want {
+				t.Errorf("Body = %q (%d bytes), want %q", statusErr.Body, len(statusErr.Body), tt.want)
+			}
+			if !strings.HasSuffix(err.Error(), ": "+tt.want) {
+				t.Errorf("message %q does not end with the body", err.Error())
+			}
+		})
+	}
+}
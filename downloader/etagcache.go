@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// etagIndexName is the name of the file, kept in the output directory, that
+// records the URL and ETag each file in the directory was downloaded from.
+// Entries without an ETag only record the URL.
+const etagIndexName = ".dwny-etags.json"
+
+// etagEntry describes a completed download in the ETag index. The size guards
+// against the file having been replaced or truncated since.
+type etagEntry struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+func (d *Downloader) etagIndexPath() string {
+	return filepath.Join(filepath.Dir(d.outputPath), etagIndexName)
+}
+
+// etagIndexKey is the key of the output file in the index of its directory.
+func (d *Downloader) etagIndexKey() string {
+	return filepath.Base(d.outputPath)
+}
+
+// etagUnchanged reports whether the output file was downloaded from the URL
+// and is still as it was saved, and the remote ETag matches the one recorded
+// then.
+func (d *Downloader) etagUnchanged(etag string) (bool, error) {
+	if etag == "" {
+		return false, nil
+	}
+	info, err := d.storage.Stat(d.outputPath)
+	if err != nil {
+		return false, nil
+	}
+
+	index, err := d.loadETagIndex(d.etagIndexPath())
+	if err != nil {
+		return false, err
+	}
+	entry, ok := index[d.etagIndexKey()]
+	return ok && entry.URL == d.url && entry.ETag == etag && entry.Size == info.Size(), nil
+}
+
+// recordDownload stores the URL of a completed download in the index, along
+// with its ETag. Downloads are recorded when their ETag is cached, or when
+// their name was case-normalized, for checkNameCollision.
+func (d *Downloader) recordDownload(etag string) error {
+	if !(d.opts.ETagCache && etag != "") && !d.normalizesNameCase() {
+		return nil
+	}
+	info, err := d.storage.Stat(d.outputPath)
+	if err != nil {
+		return err
+	}
+
+	path := d.etagIndexPath()
+	index, err := d.loadETagIndex(path)
+	if err != nil {
+		return err
+	}
+	index[d.etagIndexKey()] = etagEntry{URL: d.url, ETag: etag, Size: info.Size()}
+	return d.saveETagIndex(path, index)
+}
+
+// loadETagIndex returns the index at path. A missing or unreadable index,
+// such as one written by an older version, counts as empty, so every file is
+// downloaded again once.
+func (d *Downloader) loadETagIndex(path string) (map[string]etagEntry, error) {
+	index := map[string]etagEntry{}
+	data, err := readFile(d.storage, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return map[string]etagEntry{}, nil
+	}
+	return index, nil
+}
+
+// saveETagIndex replaces the index atomically, so an interrupted write never
+// leaves a corrupt index behind.
+func (d *Downloader) saveETagIndex(path string, index map[string]etagEntry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.storage, path, data)
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestETagCache(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	opts := Options{ETagCache: true}
+
+	download(t, server.URL, path, opts)
+	if n := len(server.gets()); n != 1 {
+		t.Fatalf("first run made %d GET requests, want 1", n)
+	}
+
+	// Same ETag: skipped after the HEAD request.
+	download(t, server.URL, path, opts)
+	if n := len(server.gets()); n != 1 {
+		t.Errorf("cache hit made %d more GET requests, want 0", n-1)
+	}
+
+	// Changed ETag: downloaded again.
+	changed := append([]byte("changed\n"), testContent...)
+	server.setContent(changed, `"v2"`)
+	download(t, server.URL, path, opts)
+	if n := len(server.gets()); n != 2 {
+		t.Errorf("changed ETag made %d more GET requests, want 1", n-1)
+	}
+	checkFile(t, path, changed)
+}
+
+func TestETagCacheChecksOutputFile(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	dir := t.TempDir()
+	opts := Options{ETagCache: true}
+	download(t, server.URL, filepath.Join(dir, "a"), opts)
+
+	// The URL is cached for a, not for b, which holds something else.
+	other := filepath.Join(dir, "b")
+	if err := os.WriteFile(other, []byte("unrelated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	download(t, server.URL, other, opts)
+	checkFile(t, other, testContent)
+
+	// A cached file that was modified since is downloaded again.
+	a := filepath.Join(dir, "a")
+	if err := os.WriteFile(a, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	download(t, server.URL, a, opts)
+	checkFile(t, a, testContent)
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Policies for URLs that name a directory rather than a file, such as
+// https://example.com/docs/.
+const (
+	// DirURLIndex saves the download as index.html.
+	DirURLIndex = "index"
+	// DirURLContentType saves the download as index, with an extension
+	// matching the Content-Type of the response.
+	DirURLContentType = "content-type"
+	// DirURLError fails the download.
+	DirURLError = "error"
+)
+
+// Policies for the case of derived filenames. Normalizing the case keeps
+// names that differ only in case from overwriting each other unnoticed on
+// case-insensitive filesystems.
+const (
+	// NameCasePreserve keeps the name as it appears in the URL.
+	NameCasePreserve = "preserve"
+	// NameCaseLower converts the name to lower case.
+	NameCaseLower = "lower"
+	// NameCaseUpper converts the name to upper case.
+	NameCaseUpper = "upper"
+)
+
+// normalizesNameCase reports whether derived filenames change case, so that
+// different URLs may map to the same name.
+func (d *Downloader) normalizesNameCase() bool {
+	return d.opts.NameCase == NameCaseLower || d.opts.NameCase == NameCaseUpper
+}
+
+// checkNameCollision fails if the output file was saved from another URL, as
+// happens when names that differ only in case are normalized to the same one.
+func (d *Downloader) checkNameCollision() error {
+	if _, err := d.storage.Stat(d.outputPath); err != nil {
+		return nil
+	}
+	index, err := d.loadETagIndex(d.etagIndexPath())
+	if err != nil {
+		return err
+	}
+	if entry, ok := index[d.etagIndexKey()]; ok && entry.URL != d.url {
+		return &NameCollisionError{URL: d.url, Path: d.outputPath, OtherURL: entry.URL}
+	}
+	return nil
+}
+
+// preferredExtensions picks an extension for common types where
+// mime.ExtensionsByType would return several.
+var preferredExtensions = map[string]string{
+	"text/html":  ".html",
+	"text/plain": ".txt",
+	"image/jpeg": ".jpg",
+}
+
+// deriveFilename returns the name to save a download under when no output
+// path is given, with its case normalized according to the NameCase policy.
+func (d *Downloader) deriveFilename(contentType string) (string, error) {
+	name, err := d.baseFilename(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	switch d.opts.NameCase {
+	case NameCaseLower:
+		return strings.ToLower(name), nil
+	case NameCaseUpper:
+		return strings.ToUpper(name), nil
+	default:
+		return name, nil
+	}
+}
+
+// baseFilename returns the last element of the URL path, or a name chosen by
+// the DirURL policy when the URL names a directory.
+func (d *Downloader) baseFilename(contentType string) (string, error) {
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Base(u.Path)
+	if u.Path != "" && u.Path[len(u.Path)-1] != '/' && name != "." && name != "/" {
+		return name, nil
+	}
+
+	switch d.opts.DirURL {
+	case DirURLError:
+		return "", fmt.Errorf("%s names a directory, not a file; pass an output path with -o", d.url)
+	case DirURLContentType:
+		return "index" + extensionForType(contentType), nil
+	default:
+		return "index.html", nil
+	}
+}
+
+// extensionForType returns a file extension for the given Content-Type, or an
+// empty string if none is known.
+func extensionForType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
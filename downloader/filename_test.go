@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestDirURL(t *testing.T) {
+	tests := []struct {
+		policy      string
+		contentType string
+		want        string
+	}{
+		{"", "text/html; charset=utf-8", "index.html"},
+		{DirURLIndex, "application/json", "index.html"},
+		{DirURLContentType, "application/json", "index.json"},
+		{DirURLContentType, "text/plain; charset=utf-8", "index.txt"},
+		{DirURLContentType, "application/x-unknown", "index"},
+		{DirURLError, "text/html", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+tt.contentType, func(t *testing.T) {
+			srv := newTestServer(t, testContent, "")
+			srv.contentType = tt.contentType
+			storage := newMemStorage()
+
+			result, err := newTestDownloader(t, srv.URL+"/docs/", "", Options{DirURL: tt.policy, Storage: storage}).Download(context.Background())
+
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("saved %v, want an error", storage.names())
+				}
+				if len(srv.gets()) != 0 {
+					t.Errorf("fetched the file despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.OutputPath != tt.want {
+				t.Errorf("OutputPath = %q, want %q", result.OutputPath, tt.want)
+			}
+			if names := storage.names(); !slices.Equal(names, []string{tt.want}) {
+				t.Errorf("saved %v, want %s", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaseFilename(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/a/b.tar.gz", "b.tar.gz"},
+		{"http://example.com/a/b.tar.gz?token=x", "b.tar.gz"},
+		{"http://example.com/a/", "index.html"},
+		{"http://example.com/", "index.html"},
+		{"http://example.com", "index.html"},
+	}
+	for _, tt := range tests {
+		got, err := newTestDownloader(t, tt.url, "", Options{}).baseFilename("")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.url, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: name = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNameCase(t *testing.T) {
+	urls := []string{"http://example.com/Report.PDF", "http://example.com/report.pdf"}
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{"", []string{"Report.PDF", "report.pdf"}},
+		{NameCasePreserve, []string{"Report.PDF", "report.pdf"}},
+		{NameCaseLower, []string{"report.pdf", "report.pdf"}},
+		{NameCaseUpper, []string{"REPORT.PDF", "REPORT.PDF"}},
+	}
+	for _, tt := range tests {
+		for i, u := range urls {
+			got, err := newTestDownloader(t, u, "", Options{NameCase: tt.policy}).deriveFilename("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want[i] {
+				t.Errorf("%q: %s saved as %q, want %q", tt.policy, u, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestNameCaseDownload(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	storage := newMemStorage()
+
+	result := download(t, srv.URL+"/Data/Report.PDF", "", Options{NameCase: NameCaseLower, Storage: storage})
+
+	if result.OutputPath != "report.pdf" {
+		t.Errorf("OutputPath = %q, want report.pdf", result.OutputPath)
+	}
+	names := storage.names()
+	slices.Sort(names)
+	if want := []string{etagIndexName, "report.pdf"}; !slices.Equal(names, want) {
+		t.Errorf("saved %v, want %v", names, want)
+	}
+}
+
+func TestNameCaseCollision(t *testing.T) {
+	for _, sameSize := range []bool{true, false} {
+		t.Run(fmt.Sprint("same size ", sameSize), func(t *testing.T) {
+			first := newTestServer(t, testContent, "")
+			other := testContent
+			if !sameSize {
+				other = testContent[:1000]
+			}
+			second := newTestServer(t, bytes.ToUpper(other), "")
+			storage := newMemStorage()
+			opts := Options{NameCase: NameCaseLower, Storage: storage}
+
+			download(t, first.URL+"/Report.PDF", "", opts)
+			_, err := newTestDownloader(t, second.URL+"/report.pdf", "", opts).Download(context.Background())
+
+			var collision *NameCollisionError
+			if !errors.As(err, &collision) {
+				t.Fatalf("err = %v, want a NameCollisionError", err)
+			}
+			if collision.OtherURL != first.URL+"/Report.PDF" {
+				t.Errorf("OtherURL = %q, want the first URL", collision.OtherURL)
+			}
+			if got := storage.content("report.pdf"); !bytes.Equal(got, testContent) {
+				t.Errorf("report.pdf no longer holds the first download")
+			}
+			if n := len(second.requests); n != 1 {
+				t.Errorf("second server got %d requests, want only the probe", n)
+			}
+
+			// The same URL again is not a collision.
+			download(t, first.URL+"/Report.PDF", "", opts)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultHashAlgorithm is used when Options.HashAlgorithm is empty.
+const DefaultHashAlgorithm = "sha256"
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	if algorithm == "" {
+		algorithm = DefaultHashAlgorithm
+	}
+	newFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+	return newFunc(), nil
+}
+
+// Hash streams the file through the configured hash algorithm without saving
+// it, and returns the hex-encoded digest. If Options.ExpectedHash is set, a
+// different digest results in a ChecksumMismatchError. With
+// Options.Decompress, xz and zstd files are hashed as decompressed.
+// transferred is the number of bytes received, also when hashing fails.
+func (d *Downloader) Hash(ctx context.Context) (digest string, transferred int64, err error) {
+	digest, err = d.hashFile(ctx, &transferred)
+	return digest, transferred, truncateError(err, d.opts.MaxErrorLength)
+}
+
+func (d *Downloader) hashFile(ctx context.Context, transferred *int64) (string, error) {
+	h, err := newHash(d.opts.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := d.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
+
+	var body io.Reader = &countingReader{r: resp.Body, n: transferred}
+	size := getFileSize(resp)
+	if d.opts.Decompress {
+		if format := detectCompression(resp.Request.URL.Path, resp.Header.Get("Content-Type")); format != nil {
+			r, err := format.newReader(body)
+			if err != nil {
+				return "", err
+			}
+			defer r.Close()
+			// The decompressed size is not known up front.
+			body, size = r, 0
+		}
+	}
+
+	download := NewDownload(d.url, "", size)
+	if err := d.writeBody(ctx, body, h, download); err != nil {
+		return "", err
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if d.opts.ExpectedHash != "" && !strings.EqualFold(digest, d.opts.ExpectedHash) {
+		return digest, &ChecksumMismatchError{URL: d.url, Expected: d.opts.ExpectedHash, Actual: digest}
+	}
+	return digest, nil
+}
+
+// countingReader adds the number of bytes read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	*r.n += int64(n)
+	return n, err
+}
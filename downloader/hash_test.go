@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	server := newTestServer(t, []byte("hello world\n"), "")
+	tests := []struct {
+		algorithm string
+		want      string
+	}{
+		{"", "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"},
+		{"md5", "6f5902ac237024bdd0c176cb93063dc4"},
+		{"sha1", "22596363b3de40b06f981fb85d82312e8c0ed511"},
+		{"sha512", "db3974a97f2407b7cae1ae637c0030687a11913274d578492558e39c16c017de84eacdc8c62fe34ee4e12b4b1428817f09b6a2760c3f8a664ceae94d2434a593"},
+	}
+	for _, tt := range tests {
+		digest, transferred, err := newTestDownloader(t, server.URL+"/file", "", Options{HashAlgorithm: tt.algorithm}).Hash(context.Background())
+		if err != nil {
+			t.Fatalf("%q: %v", tt.algorithm, err)
+		}
+		if digest != tt.want {
+			t.Errorf("%q: digest = %s, want %s", tt.algorithm, digest, tt.want)
+		}
+		if transferred != 12 {
+			t.Errorf("%q: transferred = %d, want 12", tt.algorithm, transferred)
+		}
+	}
+}
+
+func TestHashMismatch(t *testing.T) {
+	server := newTestServer(t, []byte("hello world\n"), "")
+
+	_, _, err := newTestDownloader(t, server.URL+"/file", "", Options{ExpectedHash: "00"}).Hash(context.Background())
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err = %v, want a ChecksumMismatchError", err)
+	}
+	if mismatch.Expected != "00" || mismatch.Actual != "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447" {
+		t.Errorf("mismatch = %+v", mismatch)
+	}
+}
+
+func TestHashUnsupportedAlgorithm(t *testing.T) {
+	if _, _, err := newTestDownloader(t, "http://example.com/f", "", Options{HashAlgorithm: "crc32"}).Hash(context.Background()); err == nil {
+		t.Error("Hash succeeded with an unsupported algorithm")
+	}
+}
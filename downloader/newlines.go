@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Newline styles for Options.NormalizeNewlines.
+const (
+	NewlineLF   = "lf"
+	NewlineCRLF = "crlf"
+)
+
+// rawPath returns where a text download is saved as received when its newlines
+// are normalized, e.g. urls.txt.raw for urls.txt. Keeping the raw bytes lets
+// later runs still resume or skip the download by its size.
+func rawPath(outputPath string) string {
+	return outputPath + ".raw"
+}
+
+// normalizes reports whether the newlines of a download with the given
+// Content-Type are normalized. Only text/* files are, so binaries are never
+// touched, and compressed files are unpacked instead.
+func (d *Downloader) normalizes(contentType string) bool {
+	if d.opts.NormalizeNewlines == "" {
+		return false
+	}
+	if d.opts.Decompress && detectCompression(d.outputPath, contentType) != nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mediaType, "text/")
+}
+
+// normalizeNewlines writes the raw download at source to the output path with
+// its line endings rewritten. The output is written anew on every run, so it
+// follows a change of the newline style.
+func (d *Downloader) normalizeNewlines(source string) error {
+	newline := "\n"
+	if d.opts.NormalizeNewlines == NewlineCRLF {
+		newline = "\r\n"
+	}
+
+	src, err := d.storage.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	err = writeAtomic(d.storage, d.outputPath, func(w io.Writer) error {
+		return convertNewlines(w, src, newline)
+	})
+	if err != nil {
+		return err
+	}
+
+	d.logger.Info("Normalized newlines", zap.String("path", d.outputPath), zap.String("newline", d.opts.NormalizeNewlines))
+	return nil
+}
+
+// convertNewlines copies r to w, ending every line with newline.
+func convertNewlines(w io.Writer, r io.Reader, newline string) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for {
+		line, err := br.ReadString('\n')
+		if strings.HasSuffix(line, "\n") {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r") + newline
+		}
+		if _, werr := bw.WriteString(line); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
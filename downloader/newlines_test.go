@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeNewlines(t *testing.T) {
+	crlf := []byte(strings.Repeat("http://example.com/a\r\n", 100) + "last line\r\nno newline")
+	lf := []byte(strings.Repeat("http://example.com/a\n", 100) + "last line\nno newline")
+	server := newTestServer(t, crlf, `"v1"`)
+	server.contentType = "text/plain; charset=utf-8"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "urls.txt")
+
+	result := download(t, server.URL, path, Options{NormalizeNewlines: NewlineLF})
+	checkFile(t, path, lf)
+	checkFile(t, rawPath(path), crlf)
+	if result.OutputPath != path {
+		t.Errorf("OutputPath = %q, want %q", result.OutputPath, path)
+	}
+
+	// The raw download keeps its size, so a rerun skips it.
+	download(t, server.URL, path, Options{NormalizeNewlines: NewlineLF})
+	if n := len(server.gets()); n != 1 {
+		t.Errorf("made %d GET requests over two runs, want 1", n)
+	}
+	checkFile(t, path, lf)
+
+	download(t, server.URL, path, Options{NormalizeNewlines: NewlineCRLF})
+	checkFile(t, path, crlf)
+}
+
+func TestNormalizeNewlinesResumes(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	server.contentType = "text/plain"
+	path := filepath.Join(t.TempDir(), "file.txt")
+	prepareResume(t, rawPath(path), 1000, resumeState{Size: int64(len(testContent)), ETag: `"v1"`})
+
+	download(t, server.URL, path, Options{NormalizeNewlines: NewlineCRLF})
+
+	if got := rangesOf(server.gets()); len(got) != 1 || got[0] != "GET bytes=1000-" {
+		t.Errorf("GET requests = %q, want one for bytes=1000-", got)
+	}
+	checkFile(t, rawPath(path), testContent)
+	checkFile(t, path, []byte(strings.ReplaceAll(string(testContent), "\n", "\r\n")))
+}
+
+func TestNormalizeNewlinesSkipsBinaries(t *testing.T) {
+	binary := []byte("\x00\x01\r\n\x02")
+	server := newTestServer(t, binary, "")
+	server.contentType = "application/octet-stream"
+	dir := t.TempDir()
+
+	download(t, server.URL, filepath.Join(dir, "data.bin"), Options{NormalizeNewlines: NewlineLF})
+	checkFile(t, filepath.Join(dir, "data.bin"), binary)
+	if _, err := os.Stat(rawPath(filepath.Join(dir, "data.bin"))); !os.IsNotExist(err) {
+		t.Errorf("binary download was kept raw: %v", err)
+	}
+}
+
+func TestNormalizeNewlinesMemStorage(t *testing.T) {
+	server := newTestServer(t, []byte("a\r\nb\r\n"), "")
+	server.contentType = "text/plain"
+	storage := newMemStorage()
+
+	download(t, server.URL, "lists/urls.txt", Options{NormalizeNewlines: NewlineLF, Storage: storage})
+	if got := string(storage.content("lists/urls.txt")); got != "a\nb\n" {
+		t.Errorf("output = %q, want %q", got, "a\nb\n")
+	}
+	if got := string(storage.content("lists/urls.txt.raw")); got != "a\r\nb\r\n" {
+		t.Errorf("raw download = %q, want %q", got, "a\r\nb\r\n")
+	}
+}
@@ -0,0 +1,27 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// preallocate reserves size bytes for file before it is written, reducing
+// fragmentation and surfacing a full disk before any data is transferred.
+// Where fallocate is not available, the file is extended with Truncate
+// instead, which does not reserve blocks on most filesystems. It returns
+// errors.ErrUnsupported if file supports neither.
+func preallocate(file io.Writer, size int64) error {
+	if f, ok := file.(*os.File); ok {
+		err := fallocate(f, size)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+
+	t, ok := file.(interface{ Truncate(size int64) error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return t.Truncate(size)
+}
@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPreallocate(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	headersSent := make(chan struct{})
+	checked := make(chan struct{})
+	srv.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Length", strconv.Itoa(len(testContent)))
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			close(headersSent)
+			<-checked
+			w.Write(testContent)
+			return nil
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := newTestDownloader(t, srv.URL+"/file", path, Options{Preallocate: true}).Download(context.Background())
+		errc <- err
+	}()
+
+	<-headersSent
+	var size int64
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if info, err := os.Stat(path); err == nil {
+			if size = info.Size(); size == int64(len(testContent)) {
+				break
+			}
+		}
+	}
+	close(checked)
+	if size != int64(len(testContent)) {
+		t.Errorf("file has %d bytes before any content, want the full %d", size, len(testContent))
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkFile(t, path, testContent)
+}
+
+func TestPreallocateDiskFull(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+
+	result, err := newTestDownloader(t, srv.URL+"/file", "file", Options{
+		Preallocate: true,
+		Storage:     fullStorage{newMemStorage()},
+	}).Download(context.Background())
+
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("err = %v, want ENOSPC", err)
+	}
+	if result.BytesDownloaded != 0 {
+		t.Errorf("BytesDownloaded = %d, want the failure before any transfer", result.BytesDownloaded)
+	}
+}
+
+func TestPreallocateUnsupported(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	storage := newMemStorage()
+
+	download(t, srv.URL+"/file", "file", Options{Preallocate: true, Storage: storage})
+
+	if got := storage.content("file"); string(got) != string(testContent) {
+		t.Errorf("stored %d bytes, want %d", len(got), len(testContent))
+	}
+}
+
+// fullStorage is a storage whose files cannot be extended, as on a full disk.
+type fullStorage struct {
+	*memStorage
+}
+
+func (s fullStorage) Create(name string) (io.WriteCloser, error) {
+	w, err := s.memStorage.Create(name)
+	return fullFile{w}, err
+}
+
+type fullFile struct {
+	io.WriteCloser
+}
+
+func (fullFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: "file", Err: syscall.ENOSPC}
+}
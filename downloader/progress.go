@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Progress modes.
+const (
+	ProgressBar  = "bar"
+	ProgressKV   = "kv"
+	ProgressLog  = "log"
+	ProgressNone = "none"
+)
+
+// Default intervals between periodic progress reports. Log lines are spaced
+// further apart so they don't flood the log.
+const (
+	DefaultProgressInterval    = time.Second
+	DefaultLogProgressInterval = 10 * time.Second
+)
+
+// progressRenderer shows the progress of a download. update is called after
+// every write and finish once the download completes.
+type progressRenderer interface {
+	update(download *Download)
+	finish(download *Download)
+}
+
+func newProgressRenderer(opts Options, logger *zap.Logger) progressRenderer {
+	switch opts.Progress {
+	case ProgressKV:
+		return newIntervalProgress(opts.ProgressInterval, DefaultProgressInterval, func(download *Download) {
+			printKV(os.Stdout, download)
+		})
+	case ProgressLog:
+		return newIntervalProgress(opts.ProgressInterval, DefaultLogProgressInterval, func(download *Download) {
+			logProgress(logger, download)
+		})
+	case ProgressNone:
+		return noProgress{}
+	default:
+		return barProgress{}
+	}
+}
+
+// barProgress redraws a progress bar in place on every update.
+type barProgress struct{}
+
+func (barProgress) update(download *Download) {
+	if download.totalSize == 0 {
+		fmt.Printf("\r%s: %s / unknown size", download.filename, prettySize(download.downloadedSize))
+		return
+	}
+
+	progress := float64(download.downloadedSize) / float64(download.totalSize) * 100
+	barWidth := 30
+	filledWidth := int(progress / 100 * float64(barWidth))
+	emptyWidth := barWidth - filledWidth
+
+	fmt.Printf("\r%s: [%s%s] %.2f%%", download.filename, strings.Repeat("█", filledWidth), strings.Repeat(" ", emptyWidth), progress)
+	os.Stdout.Sync()
+}
+
+func (p barProgress) finish(download *Download) {
+	p.update(download)
+	fmt.Println()
+}
+
+// intervalProgress reports progress at most once per interval, and once more
+// when the download finishes.
+type intervalProgress struct {
+	interval time.Duration
+	last     time.Time
+	report   func(download *Download)
+}
+
+func newIntervalProgress(interval time.Duration, defaultInterval time.Duration, report func(download *Download)) *intervalProgress {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &intervalProgress{interval: interval, report: report}
+}
+
+func (p *intervalProgress) update(download *Download) {
+	now := time.Now()
+	if now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	p.report(download)
+}
+
+func (p *intervalProgress) finish(download *Download) {
+	p.report(download)
+}
+
+// printKV prints a key=value progress line. It uses no cursor control, so the
+// output is easy to grep and graph.
+func printKV(w io.Writer, download *Download) {
+	if download.totalSize == 0 {
+		fmt.Fprintf(w, "url=%s bytes=%d total=unknown speed=%s/s\n", download.filename, download.downloadedSize, prettyRate(download.speed()))
+		return
+	}
+
+	progress := float64(download.downloadedSize) / float64(download.totalSize) * 100
+	fmt.Fprintf(w, "url=%s bytes=%d total=%d pct=%.1f speed=%s/s\n", download.filename, download.downloadedSize, download.totalSize, progress, prettyRate(download.speed()))
+}
+
+// logProgress reports progress as an INFO log line, for when no terminal is
+// attached.
+func logProgress(logger *zap.Logger, download *Download) {
+	fields := []zap.Field{
+		zap.String("url", download.filename),
+		zap.String("downloaded", prettySize(download.downloadedSize)),
+		zap.String("speed", prettyRate(download.speed())+"/s"),
+	}
+	if download.totalSize > 0 {
+		progress := float64(download.downloadedSize) / float64(download.totalSize) * 100
+		fields = append(fields, zap.String("progress", fmt.Sprintf("%.1f%%", progress)))
+	}
+	logger.Info("Download progress", fields...)
+}
+
+type noProgress struct{}
+
+func (noProgress) update(*Download) {}
+func (noProgress) finish(*Download) {}
+
+// prettyRate formats a byte rate with one decimal, e.g. "3.4MB".
+func prettyRate(rate float64) string {
+	suffixes := []string{"B", "KB", "MB", "GB", "TB"}
+
+	i := 0
+	for rate > 1024 && i < len(suffixes)-1 {
+		rate /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f%s", rate, suffixes[i])
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPrintKV(t *testing.T) {
+	tests := []struct {
+		name      string
+		totalSize int64
+		want      string
+	}{
+		{"known size", 456, `^url=http://example\.com/f bytes=123 total=456 pct=27\.0 speed=[0-9.]+[KMGT]?B/s\n$`},
+		{"unknown size", 0, `^url=http://example\.com/f bytes=123 total=unknown speed=[0-9.]+[KMGT]?B/s\n$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			download := NewDownload("http://example.com/f", "f", tt.totalSize)
+			download.begin()
+			download.downloadedSize = 123
+
+			var buf bytes.Buffer
+			printKV(&buf, download)
+			if !regexp.MustCompile(tt.want).MatchString(buf.String()) {
+				t.Errorf("got %q, want a line matching %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestIntervalProgressCadence(t *testing.T) {
+	reports := 0
+	p := newIntervalProgress(time.Minute, DefaultLogProgressInterval, func(*Download) { reports++ })
+	download := NewDownload("http://example.com/f", "f", 1000)
+
+	for range 100 {
+		p.update(download)
+	}
+	if reports != 1 {
+		t.Fatalf("%d reports for a burst of updates, want 1", reports)
+	}
+
+	p.last = p.last.Add(-time.Minute)
+	p.update(download)
+	p.update(download)
+	if reports != 2 {
+		t.Errorf("%d reports after the interval passed, want 2", reports)
+	}
+
+	p.finish(download)
+	if reports != 3 {
+		t.Errorf("%d reports after finish, want 3", reports)
+	}
+}
+
+func TestLogProgress(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	p := newProgressRenderer(Options{Progress: ProgressLog}, zap.New(core)).(*intervalProgress)
+	if p.interval != DefaultLogProgressInterval {
+		t.Errorf("interval = %v, want %v", p.interval, DefaultLogProgressInterval)
+	}
+
+	download := NewDownload("http://example.com/f", "f", 1000)
+	download.begin()
+	download.downloadedSize = 250
+	p.update(download)
+	p.update(download)
+	p.finish(download)
+
+	entries := logs.FilterMessage("Download progress").All()
+	if len(entries) != 2 {
+		t.Fatalf("got %d progress lines, want 2", len(entries))
+	}
+	if got := entries[0].ContextMap()["progress"]; got != "25.0%" {
+		t.Errorf("progress = %v, want 25.0%%", got)
+	}
+}
@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"math/bits"
+	"time"
+)
+
+// Default bounds of the read buffer.
+const (
+	DefaultMinReadBuffer = 1 << 10
+	DefaultMaxReadBuffer = 1 << 20
+)
+
+const (
+	// adaptInterval is how often the read buffer is resized.
+	adaptInterval = 250 * time.Millisecond
+	// readTarget is how much of the transfer a single read should cover:
+	// about 10ms worth of data at the measured throughput.
+	readTarget = 10 * time.Millisecond
+)
+
+// adaptiveBuffer is a read buffer that grows on fast links, saving syscalls,
+// and shrinks on slow ones, where a large buffer would rarely be filled.
+type adaptiveBuffer struct {
+	buf              []byte
+	minSize, maxSize int
+
+	windowStart time.Time
+	windowBytes int64
+}
+
+func newAdaptiveBuffer(minSize, maxSize int) *adaptiveBuffer {
+	if minSize <= 0 {
+		minSize = DefaultMinReadBuffer
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxReadBuffer
+	}
+	maxSize = max(maxSize, minSize)
+	return &adaptiveBuffer{buf: make([]byte, minSize), minSize: minSize, maxSize: maxSize, windowStart: time.Now()}
+}
+
+// observe records a read of n bytes and resizes the buffer once per
+// adaptInterval based on the throughput seen since the last resize.
+func (b *adaptiveBuffer) observe(n int) {
+	b.windowBytes += int64(n)
+	elapsed := time.Since(b.windowStart)
+	if elapsed < adaptInterval {
+		return
+	}
+
+	rate := float64(b.windowBytes) / elapsed.Seconds()
+	size := min(max(nextPowerOfTwo(int(rate*readTarget.Seconds())), b.minSize), b.maxSize)
+	if size != len(b.buf) {
+		b.buf = make([]byte, size)
+	}
+	b.windowStart = time.Now()
+	b.windowBytes = 0
+}
+
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAdaptiveBuffer(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int // bytes per second
+		want int
+	}{
+		{"slow link", 1000, 1 << 10},
+		{"medium link", 10 << 20, 128 << 10},
+		{"fast link", 1 << 30, 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newAdaptiveBuffer(0, 0)
+			b.windowStart = time.Now().Add(-time.Second)
+			b.observe(tt.rate)
+			if len(b.buf) != tt.want {
+				t.Errorf("buffer size = %d, want %d", len(b.buf), tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveBufferWaitsForInterval(t *testing.T) {
+	b := newAdaptiveBuffer(4<<10, 64<<10)
+	for range 100 {
+		b.observe(1 << 30)
+	}
+	if len(b.buf) != 4<<10 {
+		t.Errorf("buffer resized to %d before adaptInterval passed", len(b.buf))
+	}
+
+	b.windowStart = b.windowStart.Add(-adaptInterval)
+	b.observe(0)
+	if len(b.buf) != 64<<10 {
+		t.Errorf("buffer size = %d, want the maximum of %d", len(b.buf), 64<<10)
+	}
+}
+
+// zeroReader is an endless, fast source of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// BenchmarkReadBuffer streams from a fast reader through a buffer that stays
+// small and through one that adapts to the throughput.
+func BenchmarkReadBuffer(b *testing.B) {
+	for _, bench := range []struct {
+		name     string
+		min, max int
+	}{
+		{"fixed 1KB", 1 << 10, 1 << 10},
+		{"adaptive", DefaultMinReadBuffer, DefaultMaxReadBuffer},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			d := NewDownloader(context.Background(), "", "", Options{
+				Progress:      ProgressNone,
+				MinReadBuffer: bench.min,
+				MaxReadBuffer: bench.max,
+			}, zap.NewNop())
+			const chunk = 64 << 10
+			b.SetBytes(chunk)
+			body := io.LimitReader(zeroReader{}, int64(b.N)*chunk)
+			if err := d.writeBody(context.Background(), body, io.Discard, NewDownload("f", "f", 0)); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
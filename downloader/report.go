@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxReportedFailures is how many of the most recent failures a status
+// report lists.
+const maxReportedFailures = 3
+
+// statusReporter periodically writes a summary of the run, independent of the
+// progress display. It is fed by reportingProgress and the retry loop.
+type statusReporter struct {
+	w        io.Writer
+	interval time.Duration
+
+	mu         sync.Mutex
+	files      int
+	done       int
+	downloaded int64
+	total      int64
+	failures   []string
+}
+
+func newStatusReporter(w io.Writer, interval time.Duration) *statusReporter {
+	return &statusReporter{w: w, interval: interval}
+}
+
+// start counts a download that is about to begin.
+func (r *statusReporter) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files++
+}
+
+func (r *statusReporter) observe(download *Download) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downloaded = download.downloadedSize
+	r.total = download.totalSize
+}
+
+func (r *statusReporter) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, err.Error())
+	if len(r.failures) > maxReportedFailures {
+		r.failures = r.failures[1:]
+	}
+}
+
+func (r *statusReporter) complete() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done++
+}
+
+// run writes a report every interval until stop is closed.
+func (r *statusReporter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.mu.Lock()
+	last := r.downloaded
+	r.mu.Unlock()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			last = r.report(last)
+		}
+	}
+}
+
+// report writes one summary line and returns the byte count it was based
+// on, from which the next report measures the current speed.
+func (r *statusReporter) report(last int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The count drops when a retry starts the file over, after which all of
+	// it was received since the last report.
+	if r.downloaded < last {
+		last = 0
+	}
+	speed := float64(r.downloaded-last) / r.interval.Seconds()
+	line := fmt.Sprintf("dwny: %d/%d files done, %s of %s, %s/s", r.done, r.files, prettySize(r.downloaded), prettySize(r.total), prettyRate(speed))
+	if speed > 0 && r.total > 0 {
+		eta := time.Duration(float64(r.total-r.downloaded) / speed * float64(time.Second))
+		line += ", ETA " + eta.Round(time.Second).String()
+	}
+	if len(r.failures) > 0 {
+		line += ", recent failures: " + strings.Join(r.failures, "; ")
+	}
+	fmt.Fprintln(r.w, line)
+	return r.downloaded
+}
+
+// reportingProgress feeds a statusReporter alongside the progress display.
+type reportingProgress struct {
+	progressRenderer
+	reporter *statusReporter
+}
+
+func (p reportingProgress) update(download *Download) {
+	p.progressRenderer.update(download)
+	p.reporter.observe(download)
+}
+
+func (p reportingProgress) finish(download *Download) {
+	p.progressRenderer.finish(download)
+	p.reporter.observe(download)
+}
@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n")
+}
+
+func TestStatusReporterCadence(t *testing.T) {
+	var buf syncBuffer
+	interval := 20 * time.Millisecond
+	r := newStatusReporter(&buf, interval)
+	r.start()
+	r.observe(&Download{downloadedSize: 100, totalSize: 1000})
+	r.fail(errors.New("connection reset"))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.run(stop)
+	}()
+	time.Sleep(5*interval + interval/2)
+	close(stop)
+	<-done
+
+	lines := buf.lines()
+	if len(lines) < 3 || len(lines) > 6 {
+		t.Errorf("got %d reports in 5.5 intervals, want about 5", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "dwny: 0/1 files done, 100 B of 1000 B") || !strings.Contains(lines[0], "recent failures: connection reset") {
+		t.Errorf("report = %q", lines[0])
+	}
+
+	time.Sleep(2 * interval)
+	if after := buf.lines(); len(after) != len(lines) {
+		t.Errorf("reports continued after stop")
+	}
+}
+
+func TestReportStopsWithDownload(t *testing.T) {
+	server := newTestServer(t, testContent, "")
+	var buf syncBuffer
+	interval := 5 * time.Millisecond
+	d := newTestDownloader(t, server.URL, filepath.Join(t.TempDir(), "file"), Options{ReportInterval: interval})
+	d.reporter.w = &buf
+
+	if _, err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	before := len(buf.lines())
+	time.Sleep(4 * interval)
+	if after := len(buf.lines()); after != before {
+		t.Errorf("%d reports written after Download returned", after-before)
+	}
+}
+
+func TestReportAfterRestart(t *testing.T) {
+	var buf syncBuffer
+	r := newStatusReporter(&buf, time.Second)
+	r.start()
+	r.observe(&Download{downloadedSize: 2048, totalSize: 4096})
+
+	// A retry started the file over and has received 1 KB since.
+	r.observe(&Download{downloadedSize: 1024, totalSize: 4096})
+	if last := r.report(3072); last != 1024 {
+		t.Errorf("report returned %d, want 1024", last)
+	}
+
+	if line := buf.lines()[0]; !strings.Contains(line, ", 1024.0B/s, ETA 3s") {
+		t.Errorf("report = %q, want the speed since the restart", line)
+	}
+}
@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+)
+
+// resumeState records the validators of a download while it is in progress,
+// so that a later run only resumes it if the remote file did not change. It
+// is kept next to the output file, in the same Storage, and removed once the
+// download completes.
+type resumeState struct {
+	Size int64  `json:"size"`
+	ETag string `json:"etag,omitempty"`
+}
+
+func resumeStatePath(outputPath string) string {
+	return outputPath + ".dwny"
+}
+
+// loadResumeState returns the recorded state, or nil if there is none.
+func (d *Downloader) loadResumeState(path string) (*resumeState, error) {
+	data, err := readFile(d.storage, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state resumeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		// A corrupt state can't vouch for the partial file.
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (d *Downloader) saveResumeState(path string, state resumeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.storage, path, data)
+}
+
+func (d *Downloader) removeResumeState(path string) error {
+	err := d.storage.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// prepareResume leaves a partial download of testContent at path, with the
+// given state recorded for it.
+func prepareResume(t *testing.T, path string, partial int, state resumeState) {
+	t.Helper()
+	if err := os.WriteFile(path, testContent[:partial], 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDownloader(t, "", path, Options{})
+	if err := d.saveResumeState(resumeStatePath(path), state); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeWithMatchingValidators(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	path := filepath.Join(t.TempDir(), "file")
+	prepareResume(t, path, 1000, resumeState{Size: int64(len(testContent)), ETag: `"v1"`})
+
+	result := download(t, server.URL, path, Options{})
+
+	checkFile(t, path, testContent)
+	gets := server.gets()
+	if len(gets) != 1 || gets[0].Header.Get("Range") != "bytes=1000-" {
+		t.Fatalf("GET requests = %v, want one for bytes=1000-", rangesOf(gets))
+	}
+	if got := gets[0].Header.Get("If-Range"); got != `"v1"` {
+		t.Errorf("If-Range = %q, want %q", got, `"v1"`)
+	}
+	if want := int64(len(testContent) - 1000); result.BytesDownloaded != want {
+		t.Errorf("BytesDownloaded = %d, want %d", result.BytesDownloaded, want)
+	}
+	if _, err := os.Stat(resumeStatePath(path)); !os.IsNotExist(err) {
+		t.Errorf("resume state left behind: %v", err)
+	}
+}
+
+func TestResumeRestartsOnMismatch(t *testing.T) {
+	size := int64(len(testContent))
+	tests := []struct {
+		name  string
+		state *resumeState
+	}{
+		{name: "size changed", state: &resumeState{Size: size + 1, ETag: `"v1"`}},
+		{name: "etag changed", state: &resumeState{Size: size, ETag: `"v0"`}},
+		{name: "etag gone", state: &resumeState{Size: size}},
+		{name: "no state", state: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, testContent, `"v1"`)
+			path := filepath.Join(t.TempDir(), "file")
+			// The partial file holds different bytes, which must not survive
+			// a restart.
+			if err := os.WriteFile(path, make([]byte, 1000), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.state != nil {
+				d := newTestDownloader(t, "", path, Options{})
+				if err := d.saveResumeState(resumeStatePath(path), *tt.state); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			download(t, server.URL, path, Options{})
+
+			checkFile(t, path, testContent)
+			gets := server.gets()
+			if len(gets) != 1 || gets[0].Header.Get("Range") != "" {
+				t.Errorf("GET requests = %v, want one without Range", rangesOf(gets))
+			}
+		})
+	}
+}
+
+func TestDownloadToMemStorage(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	storage := newMemStorage()
+
+	download(t, server.URL, "bucket/key.bin", Options{Storage: storage, ETagCache: true})
+
+	if got := storage.content("bucket/key.bin"); string(got) != string(testContent) {
+		t.Errorf("stored %d bytes, want %d", len(got), len(testContent))
+	}
+	for _, name := range storage.names() {
+		if name != "bucket/key.bin" && name != "bucket/"+etagIndexName {
+			t.Errorf("unexpected file %s left in storage", name)
+		}
+	}
+	if _, err := os.Stat("bucket"); !os.IsNotExist(err) {
+		t.Errorf("download touched the local filesystem: %v", err)
+	}
+
+	// A partial file in storage is resumed from the state kept beside it.
+	storage = newMemStorage()
+	file, _ := storage.Create("bucket/key.bin")
+	file.Write(testContent[:500])
+	d := newTestDownloader(t, server.URL, "bucket/key.bin", Options{Storage: storage})
+	if err := d.saveResumeState(resumeStatePath("bucket/key.bin"), resumeState{Size: int64(len(testContent)), ETag: `"v1"`}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.content("bucket/key.bin"); string(got) != string(testContent) {
+		t.Errorf("resumed file has %d bytes, want %d", len(got), len(testContent))
+	}
+	if gets := server.gets(); gets[len(gets)-1].Header.Get("Range") != "bytes=500-" {
+		t.Errorf("last GET range = %q, want bytes=500-", gets[len(gets)-1].Header.Get("Range"))
+	}
+}
+
+func TestResumeCompleteFileGets416(t *testing.T) {
+	// The HEAD response announces a larger file than the one served, so the
+	// complete local file looks partial and its resume asks for a range past
+	// the end.
+	staleSize := int64(len(testContent) + 100)
+	tests := []struct {
+		name    string
+		local   []byte
+		wantErr bool
+	}{
+		{name: "complete", local: testContent},
+		{name: "longer than served", local: append(bytes.Clone(testContent), make([]byte, 50)...), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, testContent, "")
+			server.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+				if r.Method == http.MethodHead {
+					w.Header().Set("Content-Length", strconv.FormatInt(staleSize, 10))
+					w.WriteHeader(http.StatusOK)
+					return nil
+				}
+				return w
+			}
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, tt.local, 0644); err != nil {
+				t.Fatal(err)
+			}
+			d := newTestDownloader(t, server.URL, path, Options{})
+			if err := d.saveResumeState(resumeStatePath(path), resumeState{Size: staleSize}); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := d.Download(context.Background())
+
+			gets := server.gets()
+			if len(gets) != 1 || gets[0].Header.Get("Range") != fmt.Sprintf("bytes=%d-", len(tt.local)) {
+				t.Errorf("GET requests = %v, want one range request past the end", rangesOf(gets))
+			}
+			if tt.wantErr {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+					t.Fatalf("err = %v, want a StatusError for the 416", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			checkFile(t, path, testContent)
+			if result.BytesDownloaded != 0 {
+				t.Errorf("BytesDownloaded = %d, want 0", result.BytesDownloaded)
+			}
+			if _, err := os.Stat(resumeStatePath(path)); !os.IsNotExist(err) {
+				t.Errorf("resume state left behind: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestRetryOnResetBeforeFirstByte(t *testing.T) {
+	for _, resetRetries := range []int{0, 2} {
+		t.Run(fmt.Sprint("reset retries ", resetRetries), func(t *testing.T) {
+			server := newTestServer(t, testContent, `"v1"`)
+			server.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+				if n < 2 {
+					resetConn(w)
+					return nil
+				}
+				return w
+			}
+			path := filepath.Join(t.TempDir(), "file")
+
+			_, err := newTestDownloader(t, server.URL, path, Options{ResetRetries: resetRetries}).Download(context.Background())
+			if resetRetries == 0 {
+				if err == nil || !isConnReset(err) {
+					t.Fatalf("err = %v, want a connection reset", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, path, testContent)
+		})
+	}
+}
+
+func TestRetryOnResetMidDownload(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	server.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodGet && len(server.gets()) == 1 {
+			return &cutOffWriter{ResponseWriter: w, n: 30000}
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	download(t, server.URL, path, Options{ResetRetries: 1})
+
+	checkFile(t, path, testContent)
+	if got, want := rangesOf(server.gets()), []string{"GET ", "GET bytes=30000-"}; !slices.Equal(got, want) {
+		t.Errorf("GET requests = %q, want %q", got, want)
+	}
+}
+
+func TestRetryIgnoresDecompressionErrors(t *testing.T) {
+	compressed := xzFixture(t, testContent)
+	server := newTestServer(t, compressed[:len(compressed)-10], "")
+	path := filepath.Join(t.TempDir(), "file.xz")
+
+	_, err := newTestDownloader(t, server.URL+"/file.xz", path, Options{Decompress: true, Retries: 3, ResetRetries: 3}).Download(context.Background())
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want an unexpected EOF from the xz reader", err)
+	}
+	if n := len(server.gets()); n != 1 {
+		t.Errorf("made %d GET requests, want 1", n)
+	}
+}
+
+func TestIsConnReset(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"closed before response", &url.Error{Op: "Head", URL: "u", Err: io.EOF}, true},
+		{"reset before response", &url.Error{Op: "Get", URL: "u", Err: reset}, true},
+		{"body cut short", &TransferError{URL: "u", Err: io.ErrUnexpectedEOF}, true},
+		{"body reset", &TransferError{URL: "u", Err: reset}, true},
+		{"dial refused", &url.Error{Op: "Get", URL: "u", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNRESET)}}, false},
+		{"ssh handshake", &url.Error{Op: "Get", URL: "u", Err: fmt.Errorf("SSH handshake with jump host h: %w", io.EOF)}, false},
+		{"truncated stream", fmt.Errorf("xz: %w", io.ErrUnexpectedEOF), false},
+		{"status", &StatusError{URL: "u", StatusCode: 503}, false},
+	}
+	for _, tt := range tests {
+		if got := isConnReset(tt.err); got != tt.want {
+			t.Errorf("%s: isConnReset = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryResumesInterruptedTransfer(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	server.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodGet && len(server.gets()) == 1 {
+			return &cutOffWriter{ResponseWriter: w, n: 50000}
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	result := download(t, server.URL, path, Options{Retries: 1})
+
+	checkFile(t, path, testContent)
+	if got, want := rangesOf(server.gets()), []string{"GET ", "GET bytes=50000-"}; !slices.Equal(got, want) {
+		t.Errorf("GET requests = %q, want %q", got, want)
+	}
+	if result.BytesDownloaded != int64(len(testContent)) {
+		t.Errorf("BytesDownloaded = %d, want %d", result.BytesDownloaded, len(testContent))
+	}
+}
+
+func TestRetryContinuesInterruptedResume(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	server.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Header.Get("Range") == "bytes=1000-" {
+			return &cutOffWriter{ResponseWriter: w, n: 20000}
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+	prepareResume(t, path, 1000, resumeState{Size: int64(len(testContent)), ETag: `"v1"`})
+
+	download(t, server.URL, path, Options{Retries: 1})
+
+	checkFile(t, path, testContent)
+	if got, want := rangesOf(server.gets()), []string{"GET bytes=1000-", "GET bytes=21000-"}; !slices.Equal(got, want) {
+		t.Errorf("GET requests = %q, want %q", got, want)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+	server.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodGet {
+			return &cutOffWriter{ResponseWriter: w, n: 1000}
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	result, err := newTestDownloader(t, server.URL, path, Options{Retries: 2}).Download(context.Background())
+	var transferErr *TransferError
+	if !errors.As(err, &transferErr) {
+		t.Fatalf("err = %v, want a TransferError", err)
+	}
+	if n := len(server.gets()); n != 3 {
+		t.Errorf("made %d GET requests, want 3", n)
+	}
+	// Each attempt got 1000 bytes further.
+	if result.BytesDownloaded != 3000 {
+		t.Errorf("BytesDownloaded = %d, want 3000", result.BytesDownloaded)
+	}
+}
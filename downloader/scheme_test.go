@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeScheme serves files from a map keyed by the host and path of the URL.
+type fakeScheme map[string][]byte
+
+func (s fakeScheme) Open(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	content, ok := s[u.Host+u.Path]
+	if !ok {
+		return nil, 0, &fs.PathError{Op: "open", Path: u.String(), Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+}
+
+func TestRegisterScheme(t *testing.T) {
+	RegisterScheme("dwnytest", fakeScheme{"bucket/data.bin": testContent})
+	storage := newMemStorage()
+
+	result := download(t, "dwnytest://bucket/data.bin", "", Options{Storage: storage})
+
+	if result.OutputPath != "data.bin" {
+		t.Errorf("OutputPath = %q, want data.bin", result.OutputPath)
+	}
+	if got := storage.content("data.bin"); !bytes.Equal(got, testContent) {
+		t.Errorf("stored %d bytes, want %d", len(got), len(testContent))
+	}
+	if result.BytesDownloaded != int64(len(testContent)) {
+		t.Errorf("BytesDownloaded = %d, want %d", result.BytesDownloaded, len(testContent))
+	}
+
+	_, err := newTestDownloader(t, "dwnytest://bucket/missing", "", Options{Storage: storage}).Download(context.Background())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v, want the handler's error", err)
+	}
+}
+
+func TestUnregisteredScheme(t *testing.T) {
+	_, err := newTestDownloader(t, "gopher://example.com/file", "", Options{Storage: newMemStorage()}).Download(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `unsupported URL scheme "gopher"`) {
+		t.Errorf("err = %v, want an unsupported scheme error", err)
+	}
+}
+
+func TestSchemeSharesDownloadSteps(t *testing.T) {
+	compressed := xzFixture(t, testContent)
+	RegisterScheme("dwnysteps", fakeScheme{
+		"b/f.xz":   compressed,
+		"b/us.txt": []byte("a\r\nb\r\n"),
+	})
+
+	t.Run("decompress", func(t *testing.T) {
+		storage := newMemStorage()
+		download(t, "dwnysteps://b/f.xz", "", Options{Storage: storage, Decompress: true, ExpectTotalSize: int64(len(compressed))})
+		if got := storage.content("f"); !bytes.Equal(got, testContent) {
+			t.Errorf("unpacked %d bytes, want %d", len(got), len(testContent))
+		}
+	})
+
+	t.Run("expected size", func(t *testing.T) {
+		_, err := newTestDownloader(t, "dwnysteps://b/f.xz", "", Options{Storage: newMemStorage(), ExpectTotalSize: 5}).Download(context.Background())
+		var mismatch *SizeMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("err = %v, want a SizeMismatchError", err)
+		}
+	})
+
+	t.Run("newlines", func(t *testing.T) {
+		storage := newMemStorage()
+		download(t, "dwnysteps://b/us.txt", "", Options{Storage: storage, NormalizeNewlines: NewlineLF})
+		if got := string(storage.content("us.txt")); got != "a\nb\n" {
+			t.Errorf("output = %q, want %q", got, "a\nb\n")
+		}
+	})
+
+	t.Run("close error", func(t *testing.T) {
+		_, err := newTestDownloader(t, "dwnysteps://b/f.xz", "file", Options{Storage: closeFailStorage{newMemStorage()}}).Download(context.Background())
+		if !errors.Is(err, errCommit) {
+			t.Errorf("err = %v, want %v", err, errCommit)
+		}
+	})
+}
+
+// failScheme fails the test if it is asked to open a URL.
+type failScheme struct {
+	t *testing.T
+}
+
+func (s failScheme) Open(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	s.t.Errorf("opened %s", u)
+	return nil, 0, errors.New("unexpected open")
+}
+
+func TestSchemeChecksOutputPathFirst(t *testing.T) {
+	RegisterScheme("dwnyfail", failScheme{t})
+	dir := t.TempDir()
+
+	_, err := newTestDownloader(t, "dwnyfail://b/f", dir, Options{}).Download(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("err = %v, want the output path rejected", err)
+	}
+}
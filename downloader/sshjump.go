@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshJump dials connections through an SSH tunnel to a jump host. The SSH
+// connection is established on the first dial and shared by later ones.
+type sshJump struct {
+	user           string
+	addr           string
+	keyPath        string
+	knownHostsPath string
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHJump parses a jump host given as [user@]host[:port]. The user
+// defaults to the current one and the port to 22.
+func newSSHJump(spec string, keyPath string, knownHostsPath string) *sshJump {
+	userName, host, ok := strings.Cut(spec, "@")
+	if !ok {
+		host = userName
+		userName = ""
+		if current, err := user.Current(); err == nil {
+			userName = current.Username
+		}
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	return &sshJump{user: userName, addr: host, keyPath: keyPath, knownHostsPath: knownHostsPath}
+}
+
+// sshHandshakeTimeout bounds connecting to the jump host, including the SSH
+// handshake, unless the context of the dial ends sooner.
+const sshHandshakeTimeout = 30 * time.Second
+
+func (j *sshJump) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := j.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, network, addr)
+	var openErr *ssh.OpenChannelError
+	if err != nil && ctx.Err() == nil && !errors.As(err, &openErr) {
+		// The jump host did not refuse the channel; the tunnel itself is
+		// gone. Drop it so the next dial connects again.
+		j.drop(client)
+	}
+	return conn, err
+}
+
+func (j *sshJump) connect(ctx context.Context) (*ssh.Client, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.client != nil {
+		return j.client, nil
+	}
+
+	config, closeAgent, err := j.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	dialer := &net.Dialer{Timeout: sshHandshakeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", j.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SSH jump host %s: %w", j.addr, err)
+	}
+
+	// ssh.NewClientConn has no timeout of its own, so a host that accepts
+	// the connection but never speaks SSH would hang it.
+	deadline := time.Now().Add(sshHandshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, j.addr, config)
+	if !stop() {
+		err = errors.Join(err, ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with jump host %s: %w", j.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	j.client = ssh.NewClient(sshConn, chans, reqs)
+	return j.client, nil
+}
+
+// drop closes client and forgets it, unless another dial replaced it already.
+func (j *sshJump) drop(client *ssh.Client) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.client == client {
+		j.client = nil
+	}
+	client.Close()
+}
+
+// clientConfig authenticates with the configured key and the SSH agent, and
+// verifies the jump host against the known hosts file. The agent is only
+// needed during the handshake; closeAgent disconnects from it.
+func (j *sshJump) clientConfig() (config *ssh.ClientConfig, closeAgent func(), err error) {
+	closeAgent = func() {}
+	var auth []ssh.AuthMethod
+	if j.keyPath != "" {
+		key, err := os.ReadFile(j.keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing SSH key %s: %w", j.keyPath, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connecting to SSH agent: %w", err)
+		}
+		closeAgent = func() { conn.Close() }
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	if len(auth) == 0 {
+		return nil, nil, errors.New("no SSH credentials: pass a key or set SSH_AUTH_SOCK")
+	}
+
+	// Close the agent connection if the rest of the setup fails.
+	defer func() {
+		if err != nil {
+			closeAgent()
+		}
+	}()
+
+	knownHostsPath := j.knownHostsPath
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading known hosts: %w", err)
+	}
+
+	config = &ssh.ClientConfig{
+		User:            j.user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+	return config, closeAgent, nil
+}
@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTestServer is an SSH server that accepts one client key and forwards
+// direct-tcpip channels, as a jump host does.
+type sshTestServer struct {
+	listener       net.Listener
+	keyPath        string
+	knownHostsPath string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newSSHTestServer(t *testing.T) *sshTestServer {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	s := &sshTestServer{listener: listener, keyPath: keyPath, knownHostsPath: knownHostsPath}
+	go s.serve(config)
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropConnections()
+	})
+	return s
+}
+
+func (s *sshTestServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for ch := range chans {
+				go forwardChannel(ch)
+			}
+		}()
+	}
+}
+
+// forwardChannel connects a direct-tcpip channel to its target.
+func forwardChannel(newChannel ssh.NewChannel) {
+	if newChannel.ChannelType() != "direct-tcpip" {
+		newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		return
+	}
+	data := newChannel.ExtraData()
+	hostLen := binary.BigEndian.Uint32(data)
+	host := string(data[4 : 4+hostLen])
+	port := binary.BigEndian.Uint32(data[4+hostLen:])
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(ch, target)
+		ch.Close()
+	}()
+	io.Copy(target, ch)
+	target.Close()
+}
+
+// dropConnections closes all SSH connections, as a restarted bastion would.
+func (s *sshTestServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *sshTestServer) options() Options {
+	return Options{
+		SSHJump:       "tester@" + s.listener.Addr().String(),
+		SSHKey:        s.keyPath,
+		SSHKnownHosts: s.knownHostsPath,
+	}
+}
+
+func TestSSHJump(t *testing.T) {
+	jump := newSSHTestServer(t)
+	server := newTestServer(t, testContent, `"v1"`)
+	path := filepath.Join(t.TempDir(), "file")
+
+	download(t, server.URL, path, jump.options())
+	checkFile(t, path, testContent)
+}
+
+func TestSSHJumpReconnects(t *testing.T) {
+	jump := newSSHTestServer(t)
+	server := newTestServer(t, testContent, "")
+	opts := jump.options()
+	j := newSSHJump(opts.SSHJump, opts.SSHKey, opts.SSHKnownHosts)
+	addr := server.Listener.Addr().String()
+
+	conn, err := j.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	jump.dropConnections()
+	// The first dial after the drop may still see the dead tunnel; it must
+	// not stick.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err = j.DialContext(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial still fails after the tunnel dropped: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSHJumpHandshakeTimeout(t *testing.T) {
+	jump := newSSHTestServer(t)
+	// A host that accepts connections but never speaks SSH.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	j := newSSHJump(silent.Addr().String(), jump.keyPath, jump.knownHostsPath)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := j.DialContext(ctx, "tcp", "127.0.0.1:80"); err == nil {
+		t.Fatal("dial through a silent host succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("dial took %s, want it to end with the context", elapsed)
+	}
+}
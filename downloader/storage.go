@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Storage is where downloads are written. It lets downloads target something
+// other than the local filesystem.
+type Storage interface {
+	// Open opens the named file for reading.
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates the named file for writing.
+	Create(name string) (io.WriteCloser, error)
+	// OpenAppend opens an existing file for writing at its end.
+	OpenAppend(name string) (io.WriteCloser, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Rename replaces newname with oldname. Files written through a
+	// temporary name are moved into place with it, so a failed write never
+	// leaves a partial file behind.
+	Rename(oldname, newname string) error
+	// Remove deletes the named file. Errors for a missing file wrap
+	// fs.ErrNotExist.
+	Remove(name string) error
+}
+
+// OSStorage stores downloads on the local filesystem.
+type OSStorage struct{}
+
+func (OSStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (OSStorage) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (OSStorage) OpenAppend(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+func (OSStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSStorage) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (OSStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// readFile reads the whole named file from storage.
+func readFile(storage Storage, name string) ([]byte, error) {
+	file, err := storage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place.
+func writeFileAtomic(storage Storage, name string, data []byte) error {
+	return writeAtomic(storage, name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeAtomic creates the named file with the content written by write. The
+// content goes to a temporary file first, which is renamed into place only if
+// write succeeds, so readers never see a partial file.
+func writeAtomic(storage Storage, name string, write func(w io.Writer) error) error {
+	tmp := name + ".tmp"
+	file, err := storage.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		storage.Remove(tmp)
+		return err
+	}
+	return storage.Rename(tmp, name)
+}
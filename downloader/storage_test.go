@@ -0,0 +1,236 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStorage is a Storage that keeps files in memory.
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: map[string]*memFile{}}
+}
+
+func (s *memStorage) Open(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(f.data))), nil
+}
+
+func (s *memStorage) Create(name string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = &memFile{modTime: time.Now()}
+	return &memWriter{storage: s, name: name}, nil
+}
+
+func (s *memStorage) OpenAppend(name string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memWriter{storage: s, name: name}, nil
+}
+
+func (s *memStorage) Stat(name string) (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok {
+		return memFileInfo{name: path.Base(name), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	for other := range s.files {
+		if name == "." || strings.HasPrefix(other, name+"/") {
+			return memFileInfo{name: path.Base(name), dir: true}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *memStorage) Rename(oldname, newname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[oldname]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	delete(s.files, oldname)
+	s.files[newname] = f
+	return nil
+}
+
+func (s *memStorage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+// names returns the names of all files in s.
+func (s *memStorage) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.files {
+		names = append(names, name)
+	}
+	return names
+}
+
+// content returns the content of the named file, or nil if there is none.
+func (s *memStorage) content(name string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok {
+		return bytes.Clone(f.data)
+	}
+	return nil
+}
+
+type memWriter struct {
+	storage *memStorage
+	name    string
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.storage.mu.Lock()
+	defer w.storage.mu.Unlock()
+	f, ok := w.storage.files[w.name]
+	if !ok {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fs.ErrClosed}
+	}
+	f.data = append(f.data, p...)
+	f.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func TestWriteAtomic(t *testing.T) {
+	storage := newMemStorage()
+	if err := writeFileAtomic(storage, "dir/index.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readFile(storage, "dir/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{}" {
+		t.Errorf("content = %q, want %q", data, "{}")
+	}
+
+	failure := errors.New("write failed")
+	err = writeAtomic(storage, "dir/index.json", func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+	if got := string(storage.content("dir/index.json")); got != "{}" {
+		t.Errorf("failed write replaced the file with %q", got)
+	}
+	if names := storage.names(); len(names) != 1 {
+		t.Errorf("files = %v, want only the index", names)
+	}
+}
+
+func TestDownloadReportsCloseError(t *testing.T) {
+	server := newTestServer(t, testContent, `"v1"`)
+
+	t.Run("new file", func(t *testing.T) {
+		storage := closeFailStorage{newMemStorage()}
+		_, err := newTestDownloader(t, server.URL, "file", Options{Storage: storage}).Download(context.Background())
+		if !errors.Is(err, errCommit) {
+			t.Errorf("err = %v, want %v", err, errCommit)
+		}
+	})
+
+	t.Run("resumed file", func(t *testing.T) {
+		storage := closeFailStorage{newMemStorage()}
+		file, _ := storage.memStorage.Create("file")
+		file.Write(testContent[:500])
+		d := newTestDownloader(t, server.URL, "file", Options{Storage: storage})
+		if err := d.saveResumeState(resumeStatePath("file"), resumeState{Size: int64(len(testContent)), ETag: `"v1"`}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Download(context.Background()); !errors.Is(err, errCommit) {
+			t.Errorf("err = %v, want %v", err, errCommit)
+		}
+	})
+}
+
+var errCommit = errors.New("upload not committed")
+
+// closeFailStorage is a storage that fails to commit the download when it is
+// closed, as a cloud backend finishing an upload might.
+type closeFailStorage struct {
+	*memStorage
+}
+
+func (s closeFailStorage) Create(name string) (io.WriteCloser, error) {
+	w, err := s.memStorage.Create(name)
+	if name != "file" {
+		return w, err
+	}
+	return closeFailWriter{w}, err
+}
+
+func (s closeFailStorage) OpenAppend(name string) (io.WriteCloser, error) {
+	w, err := s.memStorage.OpenAppend(name)
+	return closeFailWriter{w}, err
+}
+
+type closeFailWriter struct {
+	io.WriteCloser
+}
+
+func (closeFailWriter) Close() error {
+	return errCommit
+}
@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// requestTimings collects connection timings of the requests made for a
+// download through httptrace.
+type requestTimings struct {
+	mu           sync.Mutex
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+	requestStart time.Time
+
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+}
+
+func (t *requestTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.requestStart = time.Now()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.connectStart == nil {
+				t.connectStart = map[string]time.Time{}
+			}
+			t.connectStart[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if start, ok := t.connectStart[network+addr]; ok && err == nil {
+				t.connect += time.Since(start)
+			}
+			delete(t.connectStart, network+addr)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tls += time.Since(t.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.ttfb = time.Since(t.requestStart)
+		},
+	}
+}
+
+func (t *requestTimings) apply(result *DownloadResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result.ConnectDuration = t.connect
+	result.TLSDuration = t.tls
+	result.TTFB = t.ttfb
+}
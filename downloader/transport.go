@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+func newTransport(opts Options) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(opts.ProxyFor)
+	if opts.SNI != "" {
+		transport.TLSClientConfig = &tls.Config{ServerName: opts.SNI}
+	}
+	if opts.SSHJump != "" {
+		jump := newSSHJump(opts.SSHJump, opts.SSHKey, opts.SSHKnownHosts)
+		transport.DialContext = jump.DialContext
+	}
+	if opts.MaxConnections > 0 {
+		limiter := &connLimiter{
+			dial:      transport.DialContext,
+			closeIdle: transport.CloseIdleConnections,
+			slots:     make(chan struct{}, opts.MaxConnections),
+		}
+		transport.DialContext = limiter.DialContext
+	}
+	if opts.StartupTimeout > 0 {
+		return &startupTimeoutTransport{base: transport, timeout: opts.StartupTimeout}
+	}
+	return transport
+}
+
+// proxyFunc selects a proxy based on the request host. An entry may be given
+// either as a bare hostname or as host:port; the more specific one wins.
+func proxyFunc(proxyFor map[string]*url.URL) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if proxy, ok := proxyFor[req.URL.Host]; ok {
+			return proxy, nil
+		}
+		if proxy, ok := proxyFor[req.URL.Hostname()]; ok {
+			return proxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+}
+
+// checkRedirect stops at redirects whose status is listed as terminal, so that
+// the redirect response itself is returned to the caller.
+func checkRedirect(opts Options) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		for i, prev := range via {
+			if prev.URL.String() == req.URL.String() {
+				return newRedirectLoopError(via[i:], req)
+			}
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.Response != nil && slices.Contains(opts.TerminalRedirects, req.Response.StatusCode) {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// connLimiter bounds the number of open connections across all hosts.
+type connLimiter struct {
+	dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	closeIdle func()
+	slots     chan struct{}
+}
+
+func (l *connLimiter) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		// Idle pooled connections hold slots too; give them up rather than
+		// wait for them to time out.
+		l.closeIdle()
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	conn, err := l.dial(ctx, network, addr)
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: sync.OnceFunc(func() { <-l.slots })}, nil
+}
+
+// limitedConn frees its connLimiter slot when closed.
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// startupTimeoutTransport fails requests whose first response byte does not
+// arrive within timeout of the request start. This catches servers that
+// accept the connection but never respond much sooner than the overall
+// timeout.
+type startupTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *startupTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timeoutErr := &StartupTimeoutError{URL: req.URL.String(), Timeout: t.timeout}
+	timer := time.AfterFunc(t.timeout, func() { cancel(timeoutErr) })
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { timer.Stop() },
+	})
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		if context.Cause(ctx) == timeoutErr {
+			err = timeoutErr
+		}
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request context once the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
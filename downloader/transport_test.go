@@ -0,0 +1,353 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxyStub is an HTTP proxy that answers every request itself, recording
+// the hosts it was asked for.
+type proxyStub struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newProxyStub(t *testing.T, content []byte) *proxyStub {
+	t.Helper()
+	p := &proxyStub{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.hosts = append(p.hosts, r.URL.Host)
+		p.mu.Unlock()
+		w.Write(content)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *proxyStub) requestedHosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestProxyFor(t *testing.T) {
+	alphaProxy := newProxyStub(t, []byte("via alpha"))
+	betaProxy := newProxyStub(t, []byte("via beta"))
+	opts := Options{ProxyFor: map[string]*url.URL{
+		"alpha.example": mustParseURL(t, alphaProxy.URL),
+		"beta.example":  mustParseURL(t, betaProxy.URL),
+	}}
+	dir := t.TempDir()
+
+	download(t, "http://alpha.example/file", filepath.Join(dir, "alpha"), opts)
+	download(t, "http://beta.example/file", filepath.Join(dir, "beta"), opts)
+
+	checkFile(t, filepath.Join(dir, "alpha"), []byte("via alpha"))
+	checkFile(t, filepath.Join(dir, "beta"), []byte("via beta"))
+	for _, host := range alphaProxy.requestedHosts() {
+		if host != "alpha.example" {
+			t.Errorf("alpha proxy got a request for %s", host)
+		}
+	}
+	for _, host := range betaProxy.requestedHosts() {
+		if host != "beta.example" {
+			t.Errorf("beta proxy got a request for %s", host)
+		}
+	}
+}
+
+func TestProxyFuncPrefersHostPort(t *testing.T) {
+	hostProxy := mustParseURL(t, "http://host-proxy:3128")
+	portProxy := mustParseURL(t, "http://port-proxy:3128")
+	proxy := proxyFunc(map[string]*url.URL{
+		"example.com":      hostProxy,
+		"example.com:8443": portProxy,
+	})
+
+	tests := []struct {
+		url  string
+		want *url.URL
+	}{
+		{"https://example.com:8443/f", portProxy},
+		{"https://example.com/f", hostProxy},
+		{"http://example.com:8080/f", hostProxy},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: proxy = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectWithoutLocation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := newTestDownloader(t, srv.URL+"/file", filepath.Join(t.TempDir(), "file"), Options{}).Download(context.Background())
+	var missing *MissingLocationError
+	if !errors.As(err, &missing) {
+		t.Fatalf("err = %v, want a MissingLocationError", err)
+	}
+	if missing.StatusCode != http.StatusFound {
+		t.Errorf("StatusCode = %d, want %d", missing.StatusCode, http.StatusFound)
+	}
+}
+
+func TestTerminalRedirects(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL+"/file", http.StatusFound)
+	}))
+	t.Cleanup(redirector.Close)
+	dir := t.TempDir()
+
+	download(t, redirector.URL+"/file", filepath.Join(dir, "followed"), Options{})
+	checkFile(t, filepath.Join(dir, "followed"), testContent)
+
+	_, err := newTestDownloader(t, redirector.URL+"/file", filepath.Join(dir, "terminal"), Options{
+		TerminalRedirects: []int{http.StatusFound},
+	}).Download(context.Background())
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusFound {
+		t.Fatalf("err = %v, want a StatusError for the 302", err)
+	}
+	if n := len(srv.gets()); n != 1 {
+		t.Errorf("target got %d GETs, want only the followed one", n)
+	}
+}
+
+func TestSNIOverride(t *testing.T) {
+	var mu sync.Mutex
+	var serverNames []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(testContent))
+	}))
+	srv.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mu.Lock()
+		serverNames = append(serverNames, hello.ServerName)
+		mu.Unlock()
+		return nil, nil
+	}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	// newSNIDownloader trusts the test server's certificate, which is valid
+	// for example.com and 127.0.0.1.
+	newSNIDownloader := func(sni string, outputPath string) *Downloader {
+		d := newTestDownloader(t, srv.URL+"/file", outputPath, Options{SNI: sni})
+		d.client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+		return d
+	}
+	dir := t.TempDir()
+
+	if _, err := newSNIDownloader("example.com", filepath.Join(dir, "file")).Download(context.Background()); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	checkFile(t, filepath.Join(dir, "file"), testContent)
+	mu.Lock()
+	if len(serverNames) == 0 {
+		t.Error("server saw no TLS handshakes")
+	}
+	for _, name := range serverNames {
+		if name != "example.com" {
+			t.Errorf("server saw SNI %q, want example.com", name)
+		}
+	}
+	mu.Unlock()
+
+	_, err := newSNIDownloader("other.invalid", filepath.Join(dir, "other")).Download(context.Background())
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("err = %v, want a certificate error for a name the server cannot prove", err)
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	const limit = 2
+	var mu sync.Mutex
+	open, maxOpen := 0, 0
+	limiter := &connLimiter{
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if open++; open > maxOpen {
+				maxOpen = open
+			}
+			client, server := net.Pipe()
+			server.Close()
+			return &closeHookConn{Conn: client, onClose: func() {
+				mu.Lock()
+				open--
+				mu.Unlock()
+			}}, nil
+		},
+		closeIdle: func() {},
+		slots:     make(chan struct{}, limit),
+	}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := limiter.DialContext(context.Background(), "tcp", "example.com:80")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+
+	if maxOpen > limit {
+		t.Errorf("%d connections were open at once, want at most %d", maxOpen, limit)
+	}
+	if open != 0 {
+		t.Errorf("%d connections left open", open)
+	}
+}
+
+func TestConnLimiterHonoursContext(t *testing.T) {
+	srv := newTestServer(t, testContent, "")
+	limiter := newTransport(Options{MaxConnections: 1}).(*http.Transport).DialContext
+	conn, err := limiter(context.Background(), "tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter(ctx, "tcp", srv.Listener.Addr().String()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second dial: err = %v, want it to wait for a free slot", err)
+	}
+}
+
+// closeHookConn calls onClose when it is closed.
+type closeHookConn struct {
+	net.Conn
+	onClose func()
+}
+
+func (c *closeHookConn) Close() error {
+	err := c.Conn.Close()
+	c.onClose()
+	return err
+}
+
+func TestStartupTimeout(t *testing.T) {
+	// The listener accepts connections but never answers on them.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	start := time.Now()
+	_, err = newTestDownloader(t, "http://"+ln.Addr().String()+"/file", filepath.Join(t.TempDir(), "file"), Options{
+		StartupTimeout: 50 * time.Millisecond,
+	}).Download(context.Background())
+
+	var timeoutErr *StartupTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("err = %v, want a StartupTimeoutError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v to give up", elapsed)
+	}
+}
+
+func TestStartupTimeoutSlowBody(t *testing.T) {
+	// Once the first byte arrived, a slow body is not cut off.
+	srv := newTestServer(t, testContent, "")
+	srv.intercept = func(n int, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Length", strconv.Itoa(len(testContent)))
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+			w.Write(testContent)
+			return nil
+		}
+		return w
+	}
+	path := filepath.Join(t.TempDir(), "file")
+
+	download(t, srv.URL+"/file", path, Options{StartupTimeout: 50 * time.Millisecond})
+
+	checkFile(t, path, testContent)
+}
+
+func TestRedirectLoop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/a", http.StatusFound)
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		default:
+			http.Redirect(w, r, "/a", http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := newTestDownloader(t, srv.URL+"/start", filepath.Join(t.TempDir(), "file"), Options{}).Download(context.Background())
+
+	var loopErr *RedirectLoopError
+	if !errors.As(err, &loopErr) {
+		t.Fatalf("err = %v, want a RedirectLoopError", err)
+	}
+	want := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/a"}
+	if !slices.Equal(loopErr.Cycle, want) {
+		t.Errorf("Cycle = %v, want %v", loopErr.Cycle, want)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// proxyRules collects repeated host=proxyurl flags.
+type proxyRules map[string]*neturl.URL
+
+func (p proxyRules) String() string {
+	rules := make([]string, 0, len(p))
+	for host, proxy := range p {
+		rules = append(rules, host+"="+proxy.String())
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, ",")
+}
+
+func (p proxyRules) Set(value string) error {
+	host, rawProxy, ok := strings.Cut(value, "=")
+	if !ok || host == "" || rawProxy == "" {
+		return fmt.Errorf("expected host=proxyurl, got %q", value)
+	}
+
+	proxy, err := neturl.Parse(rawProxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL for %s: %w", host, err)
+	}
+	if proxy.Scheme == "" || proxy.Host == "" {
+		return fmt.Errorf("invalid proxy URL for %s: %q", host, rawProxy)
+	}
+
+	p[host] = proxy
+	return nil
+}
+
+// statusCodes collects a comma-separated list of HTTP status codes.
+type statusCodes []int
+
+func (s *statusCodes) String() string {
+	codes := make([]string, len(*s))
+	for i, code := range *s {
+		codes[i] = strconv.Itoa(code)
+	}
+	return strings.Join(codes, ",")
+}
+
+func (s *statusCodes) Set(value string) error {
+	for _, field := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("invalid status code %q", field)
+		}
+		*s = append(*s, code)
+	}
+	return nil
+}
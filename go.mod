@@ -2,6 +2,14 @@ module github.com/mmynk/dwny
 
 go 1.23.2
 
-require go.uber.org/zap v1.27.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
+	go.uber.org/zap v1.27.0
+	golang.org/x/crypto v0.41.0
+)
 
-require go.uber.org/multierr v1.10.0 // indirect
+require (
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/mmynk/dwny/downloader"
 	"go.uber.org/zap"
@@ -13,10 +15,63 @@ import (
 
 var (
 	url        = flag.String("u", "", "URL to download")
-	outputPath = flag.String("o", "", "Output path")
+	outputPath = flag.String("o", "", "Output path (default: the last element of the URL path)")
+	proxyFor   = proxyRules{}
+
+	terminalRedirects statusCodes
+	sinceTime         time.Time
+
+	requestIDHeader = flag.String("request-id-header", "", "Header to send a per-download request ID in, for server-side log correlation")
+	requestID       = flag.String("request-id", "", "Fixed request ID value to send instead of a generated UUID")
+	sni             = flag.String("sni", "", "TLS server name to send and verify, overriding the URL host")
+	retries         = flag.Int("retries", 0, "Number of times to retry an interrupted download, resuming where it stopped")
+	retryDelay      = flag.Duration("retry-delay", time.Second, "How long to wait before retrying")
+	retryOnReset    = flag.Bool("retry-on-reset", true, "Retry downloads whose connection is reset, even before the first byte, up to --reset-retries times")
+	resetRetries    = flag.Int("reset-retries", 3, "Number of times to retry a reset connection with --retry-on-reset, on top of --retries")
+	reportInterval  = flag.Duration("report-interval", 0, "Write a summary of the run to stderr at this interval (0 to disable)")
+	startupTimeout  = flag.Duration("startup-timeout", 0, "Fail a request if the server sends nothing within this duration (0 for no limit)")
+	sshJump         = flag.String("ssh-jump", "", "Tunnel connections through an SSH jump host, as [user@]host[:port]")
+	sshKey          = flag.String("ssh-key", "", "Private key for the SSH jump host (the SSH agent is used too)")
+	sshKnownHosts   = flag.String("ssh-known-hosts", "", "Known hosts file to verify the SSH jump host with (default ~/.ssh/known_hosts)")
+	maxConnections  = flag.Int("max-connections", 0, "Maximum number of open connections across all hosts (0 for no limit)")
+	etagCache       = flag.Bool("etag-cache", false, "Skip the download if the remote ETag matches the one recorded last time")
+	decompress      = flag.Bool("decompress", false, "Unpack .xz and .zst downloads after they complete")
+	nameCase        = flag.String("name-case", downloader.NameCasePreserve, "Case of filenames derived from the URL: preserve, lower or upper")
+	dirURL          = flag.String("dir-url", downloader.DirURLIndex, "Name for URLs ending in /: index (index.html), content-type (index.<ext>) or error")
+	since           = flag.String("since", "", "Skip the download if the file was last modified before this RFC 3339 time")
+	newlines        = flag.String("normalize-newlines", "", "Convert the line endings of text downloads to lf or crlf")
+	allowEmpty      = flag.Bool("allow-empty", false, "Save an empty file when the server returns 204 No Content")
+	expectTotalSize = flag.Int64("expect-total-size", 0, "Abort unless the server reports this many bytes in total")
+	hashOnly        = flag.Bool("hash-only", false, "Print the digest of the content instead of saving it")
+	hashAlgorithm   = flag.String("hash-algo", downloader.DefaultHashAlgorithm, "Hash algorithm for --hash-only: md5, sha1, sha256 or sha512")
+	expectedHash    = flag.String("expect-hash", "", "Hex digest the content must match in --hash-only mode")
+	preallocate     = flag.Bool("preallocate", false, "Reserve the full file size on disk before downloading")
+	minReadBuffer   = flag.Int("min-read-buffer", downloader.DefaultMinReadBuffer, "Smallest size in bytes the read buffer shrinks to on slow links")
+	maxReadBuffer   = flag.Int("max-read-buffer", downloader.DefaultMaxReadBuffer, "Largest size in bytes the read buffer grows to on fast links")
+	writeBuffer     = flag.Int("write-buffer", downloader.DefaultWriteBufferSize, "Size in bytes of the buffer in front of the output file (0 to disable)")
+	maxErrorLength  = flag.Int("max-error-length", 0, "Truncate error messages to this many bytes (0 for no limit)")
+
+	dataCap         = flag.Int64("data-cap", 0, "Refuse to download once this many bytes have been downloaded in total (0 for no cap)")
+	usageFile       = flag.String("usage-file", defaultUsagePath(), "File the total downloaded byte count is kept in")
+	summaryOnly     = flag.Bool("summary-only", false, "Print nothing but a summary once the download ends")
+	summaryJSON     = flag.Bool("summary-json", false, "Print the --summary-only summary as JSON")
+	metricsTextfile = flag.String("metrics-textfile", "", "Write Prometheus metrics about the run to this file")
+
+	progress         = flag.String("progress", "", "Progress output: bar, kv, log or none (default: bar on a terminal, log otherwise)")
+	progressInterval = flag.Duration("progress-interval", 0, "How often kv and log progress lines are emitted (default 1s for kv, 10s for log)")
 )
 
+func init() {
+	flag.Var(proxyFor, "proxy-for", "Route requests for a host through a proxy, as host=proxyurl (repeatable)")
+	flag.Var(&terminalRedirects, "terminal-redirects", "Comma-separated redirect status codes to fail on instead of following")
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reset-usage" {
+		resetUsage(os.Args[2:])
+		return
+	}
+
 	parseFlags()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -33,12 +88,146 @@ func main() {
 	logger := setupLogger()
 	defer logger.Sync()
 
-	downloader := downloader.NewDownloader(ctx, *url, *outputPath, logger)
-	err := downloader.Download(ctx)
+	opts := downloader.Options{
+		ProxyFor:          proxyFor,
+		TerminalRedirects: terminalRedirects,
+		RequestIDHeader:   *requestIDHeader,
+		RequestID:         *requestID,
+		MaxErrorLength:    *maxErrorLength,
+		SNI:               *sni,
+		Retries:           *retries,
+		RetryDelay:        *retryDelay,
+		ResetRetries:      resetRetryBudget(),
+		ReportInterval:    reportEvery(),
+		StartupTimeout:    *startupTimeout,
+		SSHJump:           *sshJump,
+		SSHKey:            *sshKey,
+		SSHKnownHosts:     *sshKnownHosts,
+		MaxConnections:    *maxConnections,
+		ETagCache:         *etagCache,
+		Decompress:        *decompress,
+		DirURL:            *dirURL,
+		NameCase:          *nameCase,
+		Since:             sinceTime,
+		NormalizeNewlines: *newlines,
+		AllowEmpty:        *allowEmpty,
+		ExpectTotalSize:   *expectTotalSize,
+		HashAlgorithm:     *hashAlgorithm,
+		Preallocate:       *preallocate,
+		MinReadBuffer:     *minReadBuffer,
+		MaxReadBuffer:     *maxReadBuffer,
+		WriteBufferSize:   *writeBuffer,
+		ExpectedHash:      *expectedHash,
+		Progress:          progressMode(),
+		ProgressInterval:  *progressInterval,
+	}
+	downloader := downloader.NewDownloader(ctx, *url, *outputPath, opts, logger)
+	if *dataCap > 0 {
+		reached, used, err := dataCapReached(*usageFile, *dataCap)
+		if err != nil {
+			logger.Error("Failed to read usage", zap.Error(err))
+			os.Exit(1)
+		}
+		if reached {
+			logger.Error("Data cap reached, run 'dwny reset-usage' to continue", zap.Int64("used", used), zap.Int64("cap", *dataCap))
+			os.Exit(1)
+		}
+	}
+
+	if *hashOnly {
+		digest, transferred, err := downloader.Hash(ctx)
+		recordUsage(logger, transferred)
+		recordMetrics(logger, transferred, err)
+		if err != nil {
+			logger.Error("Failed to hash file", zap.Error(err))
+			os.Exit(1)
+		}
+		fmt.Printf("%s  %s\n", digest, *url)
+		return
+	}
+
+	start := time.Now()
+	result, err := downloader.Download(ctx)
+	recordUsage(logger, result.BytesDownloaded)
+	fields := []zap.Field{
+		zap.String("url", result.URL),
+		zap.Int64("bytes", result.BytesDownloaded),
+		zap.Duration("connect", result.ConnectDuration),
+		zap.Duration("tls", result.TLSDuration),
+		zap.Duration("ttfb", result.TTFB),
+	}
+	recordMetrics(logger, result.BytesDownloaded, err)
+	if *summaryOnly {
+		if err := printSummary(os.Stdout, newSummary(result, time.Since(start), err), *summaryJSON); err != nil {
+			logger.Error("Failed to print summary", zap.Error(err))
+		}
+	}
 	if err != nil {
-		logger.Error("Failed to download file", zap.Error(err))
+		logger.Error("Failed to download file", append(fields, zap.Error(err))...)
+		if ctx.Err() != nil {
+			printResumeHint(result.OutputPath)
+		}
 		os.Exit(1)
 	}
+	logger.Info("Download finished", fields...)
+}
+
+// recordMetrics adds the outcome of the run to the --metrics-textfile, if one
+// is given.
+func recordMetrics(logger *zap.Logger, transferred int64, downloadErr error) {
+	if *metricsTextfile == "" {
+		return
+	}
+	if err := writeMetrics(*metricsTextfile, transferred, downloadErr); err != nil {
+		logger.Error("Failed to write metrics", zap.Error(err))
+	}
+}
+
+// recordUsage adds the bytes transferred by this run to the total kept in
+// --usage-file. Every run is counted, with or without --data-cap, so the
+// total is complete once a cap is set.
+func recordUsage(logger *zap.Logger, transferred int64) {
+	if transferred == 0 {
+		return
+	}
+	if err := addUsage(*usageFile, transferred); err != nil {
+		logger.Error("Failed to record usage", zap.Error(err))
+	}
+}
+
+// printResumeHint tells the user how to pick up a cancelled download, if
+// anything was saved. Running the same command again resumes from the partial
+// file, so the hint repeats it, adding the output path if it was derived.
+// Nothing is printed under --summary-only, which prints only the summary.
+func printResumeHint(path string) {
+	if path == "" || *summaryOnly {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	args := os.Args[1:]
+	if *outputPath == "" {
+		args = append(args, "-o", path)
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	fmt.Fprintf(os.Stderr, "Resume with: dwny %s\n", strings.Join(quoted, " "))
+}
+
+// shellQuote quotes s for a POSIX shell, unless it only has characters that
+// need no quoting.
+func shellQuote(s string) string {
+	safe := s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 func setupLogger() *zap.Logger {
@@ -67,6 +256,48 @@ func setupLogger() *zap.Logger {
 	return logger
 }
 
+// progressMode returns the requested progress mode, falling back to a bar
+// when stdout is a terminal and periodic log lines otherwise. --summary-only
+// turns progress off.
+func progressMode() string {
+	if *summaryOnly {
+		return downloader.ProgressNone
+	}
+	if *progress != "" {
+		return *progress
+	}
+	if isTerminal(os.Stdout) {
+		return downloader.ProgressBar
+	}
+	return downloader.ProgressLog
+}
+
+// resetRetryBudget returns the number of retries for reset connections, which
+// is zero unless --retry-on-reset is on.
+func resetRetryBudget() int {
+	if !*retryOnReset {
+		return 0
+	}
+	return *resetRetries
+}
+
+// reportEvery returns the --report-interval, or zero to turn the periodic
+// report off under --summary-only.
+func reportEvery() time.Duration {
+	if *summaryOnly {
+		return 0
+	}
+	return *reportInterval
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 func parseFlags() {
 	flag.Parse()
 
@@ -74,4 +305,41 @@ func parseFlags() {
 		fmt.Println("URL is required")
 		os.Exit(1)
 	}
+
+	switch *progress {
+	case "", downloader.ProgressBar, downloader.ProgressKV, downloader.ProgressLog, downloader.ProgressNone:
+	default:
+		fmt.Println("Invalid progress mode:", *progress)
+		os.Exit(1)
+	}
+
+	if *since != "" {
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			fmt.Println("Invalid --since time:", err)
+			os.Exit(1)
+		}
+		sinceTime = t
+	}
+
+	switch *dirURL {
+	case downloader.DirURLIndex, downloader.DirURLContentType, downloader.DirURLError:
+	default:
+		fmt.Println("Invalid --dir-url policy:", *dirURL)
+		os.Exit(1)
+	}
+
+	switch *nameCase {
+	case downloader.NameCasePreserve, downloader.NameCaseLower, downloader.NameCaseUpper:
+	default:
+		fmt.Println("Invalid --name-case policy:", *nameCase)
+		os.Exit(1)
+	}
+
+	switch *newlines {
+	case "", downloader.NewlineLF, downloader.NewlineCRLF:
+	default:
+		fmt.Println("Invalid newline style:", *newlines)
+		os.Exit(1)
+	}
 }
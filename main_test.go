@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrintResumeHint(t *testing.T) {
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "big file.iso")
+	if err := os.WriteFile(partial, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		outputFlag string
+		path       string
+		want       string
+	}{
+		{
+			name:       "output given",
+			args:       []string{"dwny", "-u", "http://example.com/big.iso", "-o", partial},
+			outputFlag: partial,
+			path:       partial,
+			want:       "Resume with: dwny -u http://example.com/big.iso -o '" + partial + "'\n",
+		},
+		{
+			name: "output derived",
+			args: []string{"dwny", "-u", "http://example.com/a?b=c&d"},
+			path: partial,
+			want: "Resume with: dwny -u 'http://example.com/a?b=c&d' -o '" + partial + "'\n",
+		},
+		{
+			name: "nothing saved",
+			args: []string{"dwny", "-u", "http://example.com/big.iso"},
+			path: filepath.Join(dir, "missing"),
+		},
+		{
+			name: "cancelled before the path was known",
+			args: []string{"dwny", "-u", "http://example.com/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, &os.Args, tt.args)
+			setFlag(t, outputPath, tt.outputFlag)
+
+			got := captureOutput(t, func() { printResumeHint(tt.path) })
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"-u", "-u"},
+		{"https://example.com/a/b.tar.gz", "https://example.com/a/b.tar.gz"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"a&b", "'a&b'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
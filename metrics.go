@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeMetrics adds the outcome of the run to the counters in path, in the
+// Prometheus text exposition format, for the node_exporter textfile
+// collector. The counters carry on from the values already in the file, so
+// they count across runs; a missing file starts them from zero, which
+// Prometheus treats as a counter reset. The file is replaced atomically so
+// the collector never reads a partial one.
+func writeMetrics(path string, transferred int64, downloadErr error) error {
+	counters, err := readCounters(path)
+	if err != nil {
+		return err
+	}
+	counters["dwny_downloads_total"]++
+	if downloadErr != nil {
+		counters["dwny_failures_total"]++
+	}
+	counters["dwny_bytes_total"] += transferred
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "# HELP dwny_downloads_total Downloads attempted.")
+	fmt.Fprintln(&b, "# TYPE dwny_downloads_total counter")
+	fmt.Fprintf(&b, "dwny_downloads_total %d\n", counters["dwny_downloads_total"])
+	fmt.Fprintln(&b, "# HELP dwny_failures_total Downloads that failed.")
+	fmt.Fprintln(&b, "# TYPE dwny_failures_total counter")
+	fmt.Fprintf(&b, "dwny_failures_total %d\n", counters["dwny_failures_total"])
+	fmt.Fprintln(&b, "# HELP dwny_bytes_total Bytes downloaded.")
+	fmt.Fprintln(&b, "# TYPE dwny_bytes_total counter")
+	fmt.Fprintf(&b, "dwny_bytes_total %d\n", counters["dwny_bytes_total"])
+	fmt.Fprintln(&b, "# HELP dwny_last_run_timestamp_seconds Time the last run finished.")
+	fmt.Fprintln(&b, "# TYPE dwny_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(&b, "dwny_last_run_timestamp_seconds %d\n", time.Now().Unix())
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".dwny-metrics-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// CreateTemp makes the file private; the collector needs to read it.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readCounters returns the dwny_*_total samples of an earlier metrics file,
+// or none if there is no file yet.
+func readCounters(path string) (map[string]int64, error) {
+	counters := map[string]int64{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return counters, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || !strings.HasPrefix(name, "dwny_") || !strings.HasSuffix(name, "_total") {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			counters[name] = n
+		}
+	}
+	return counters, scanner.Err()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dwny.prom")
+
+	if err := writeMetrics(path, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMetrics(path, 20, errors.New("failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	for _, want := range []string{
+		"# TYPE dwny_downloads_total counter\ndwny_downloads_total 2\n",
+		"# TYPE dwny_failures_total counter\ndwny_failures_total 1\n",
+		"# TYPE dwny_bytes_total counter\ndwny_bytes_total 120\n",
+		"# TYPE dwny_last_run_timestamp_seconds gauge\ndwny_last_run_timestamp_seconds ",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics missing %q:\n%s", want, text)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) != 2 || !strings.HasPrefix(fields[0], "dwny_") {
+			t.Errorf("malformed sample line %q", line)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".dwny-metrics-*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestRecordMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dwny.prom")
+
+	setFlag(t, metricsTextfile, "")
+	recordMetrics(zap.NewNop(), 100, nil)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("metrics written without --metrics-textfile: %v", err)
+	}
+
+	setFlag(t, metricsTextfile, path)
+	recordMetrics(zap.NewNop(), 100, nil)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "dwny_bytes_total 100\n") {
+		t.Errorf("metrics = %q, want 100 bytes counted", data)
+	}
+}
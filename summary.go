@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mmynk/dwny/downloader"
+)
+
+// summary is the outcome of a run as printed by --summary-only.
+type summary struct {
+	URL      string  `json:"url"`
+	Output   string  `json:"output,omitempty"`
+	Bytes    int64   `json:"bytes"`
+	Seconds  float64 `json:"seconds"`
+	OK       bool    `json:"ok"`
+	Error    string  `json:"error,omitempty"`
+	duration time.Duration
+}
+
+func newSummary(result *downloader.DownloadResult, elapsed time.Duration, downloadErr error) summary {
+	s := summary{
+		URL:      result.URL,
+		Output:   result.OutputPath,
+		Bytes:    result.BytesDownloaded,
+		Seconds:  elapsed.Seconds(),
+		OK:       downloadErr == nil,
+		duration: elapsed.Round(time.Millisecond),
+	}
+	if downloadErr != nil {
+		s.Error = downloadErr.Error()
+	}
+	return s
+}
+
+// printSummary writes s to w as a single line, or as a JSON object when
+// asJSON is set.
+func printSummary(w io.Writer, s summary, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(s)
+	}
+	if !s.OK {
+		_, err := fmt.Fprintf(w, "FAILED %s after %s: %s\n", s.URL, s.duration, s.Error)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "OK %s -> %s: %d bytes in %s\n", s.URL, s.Output, s.Bytes, s.duration)
+	return err
+}
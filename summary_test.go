@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmynk/dwny/downloader"
+	"go.uber.org/zap"
+)
+
+func TestPrintSummary(t *testing.T) {
+	result := &downloader.DownloadResult{URL: "http://example.com/f", OutputPath: "f", BytesDownloaded: 1234}
+	tests := []struct {
+		name   string
+		err    error
+		asJSON bool
+		want   string
+	}{
+		{"ok", nil, false, "OK http://example.com/f -> f: 1234 bytes in 1.5s\n"},
+		{"failed", errors.New("connection reset"), false, "FAILED http://example.com/f after 1.5s: connection reset\n"},
+		{"json", nil, true, `{"url":"http://example.com/f","output":"f","bytes":1234,"seconds":1.5,"ok":true}` + "\n"},
+		{"json failed", errors.New("connection reset"), true, `{"url":"http://example.com/f","output":"f","bytes":1234,"seconds":1.5,"ok":false,"error":"connection reset"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printSummary(&buf, newSummary(result, 1500*time.Millisecond, tt.err), tt.asJSON); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+			if tt.asJSON && !json.Valid(buf.Bytes()) {
+				t.Errorf("invalid JSON: %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestSummaryOnlyIsQuiet(t *testing.T) {
+	content := []byte(strings.Repeat("x", 100000))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	setFlag(t, summaryOnly, true)
+	setFlag(t, progress, downloader.ProgressBar)
+	setFlag(t, reportInterval, time.Millisecond)
+
+	output := captureOutput(t, func() {
+		d := downloader.NewDownloader(context.Background(), srv.URL+"/f", filepath.Join(t.TempDir(), "f"), downloader.Options{
+			Progress:       progressMode(),
+			ReportInterval: reportEvery(),
+		}, zap.NewNop())
+		result, err := d.Download(context.Background())
+		if err := printSummary(os.Stdout, newSummary(result, time.Second, err), false); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if !strings.HasPrefix(output, "OK "+srv.URL+"/f -> ") || strings.Count(output, "\n") != 1 {
+		t.Errorf("output = %q, want only the summary line", output)
+	}
+}
+
+// setFlag sets a flag value for the duration of the test.
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	t.Helper()
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
+
+// captureOutput returns what f writes to stdout and stderr.
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+
+	f()
+
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	return <-done
+}
+
+func TestSummaryOnlyHasNoResumeHint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, summaryOnly, true)
+
+	if output := captureOutput(t, func() { printResumeHint(path) }); output != "" {
+		t.Errorf("output = %q, want nothing besides the summary", output)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// usage is the bandwidth accounting persisted across runs.
+type usage struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// defaultUsagePath returns where usage is kept unless --usage-file is given.
+func defaultUsagePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "dwny-usage.json"
+	}
+	return filepath.Join(dir, "dwny", "usage.json")
+}
+
+func loadUsage(path string) (usage, error) {
+	var u usage
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return u, err
+	}
+	err = json.Unmarshal(data, &u)
+	return u, err
+}
+
+func saveUsage(path string, u usage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// addUsage adds bytes to the persisted total.
+func addUsage(path string, bytes int64) error {
+	u, err := loadUsage(path)
+	if err != nil {
+		return err
+	}
+	u.Bytes += bytes
+	return saveUsage(path, u)
+}
+
+// dataCapReached reports whether the usage recorded in path has reached
+// dataCap, along with the usage.
+func dataCapReached(path string, dataCap int64) (bool, int64, error) {
+	u, err := loadUsage(path)
+	if err != nil {
+		return false, 0, err
+	}
+	return u.Bytes >= dataCap, u.Bytes, nil
+}
+
+// resetUsage implements the reset-usage subcommand, which sets the persisted
+// total back to zero.
+func resetUsage(args []string) {
+	flags := flag.NewFlagSet("reset-usage", flag.ExitOnError)
+	path := flags.String("usage-file", defaultUsagePath(), "File the downloaded byte count is kept in")
+	flags.Parse(args)
+
+	if err := saveUsage(*path, usage{}); err != nil {
+		fmt.Println("Failed to reset usage:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Usage reset")
+}
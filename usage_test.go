@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestUsageAccumulatesAcrossRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dwny", "usage.json")
+	const dataCap = 250
+
+	for run, transferred := range []int64{100, 100} {
+		reached, used, err := dataCapReached(path, dataCap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reached {
+			t.Fatalf("run %d: cap reached at %d bytes", run, used)
+		}
+		if err := addUsage(path, transferred); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u, err := loadUsage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Bytes != 200 {
+		t.Errorf("usage = %d, want 200", u.Bytes)
+	}
+
+	// The third run crosses the cap, and the one after is refused.
+	if err := addUsage(path, 100); err != nil {
+		t.Fatal(err)
+	}
+	reached, used, err := dataCapReached(path, dataCap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reached || used != 300 {
+		t.Errorf("reached = %v at %d bytes, want true at 300", reached, used)
+	}
+
+	if err := saveUsage(path, usage{}); err != nil {
+		t.Fatal(err)
+	}
+	if reached, _, _ := dataCapReached(path, dataCap); reached {
+		t.Errorf("cap still reached after reset")
+	}
+}
+
+func TestUsageMissingFile(t *testing.T) {
+	reached, used, err := dataCapReached(filepath.Join(t.TempDir(), "usage.json"), 1)
+	if err != nil || reached || used != 0 {
+		t.Errorf("got reached = %v, used = %d, err = %v; want no usage", reached, used, err)
+	}
+}
+
+func TestRecordUsageWithoutCap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	setFlag(t, usageFile, path)
+	setFlag(t, dataCap, 0)
+
+	recordUsage(zap.NewNop(), 100)
+	recordUsage(zap.NewNop(), 0)
+	recordUsage(zap.NewNop(), 50)
+
+	u, err := loadUsage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Bytes != 150 {
+		t.Errorf("usage = %d, want 150 counted without a cap", u.Bytes)
+	}
+}